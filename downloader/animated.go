@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// isAnimated reports whether the image file p of type imgType (as returned by image.DecodeConfig) has more than one
+// frame: GIFs are walked block by block, PNGs are animated with an acTL chunk of more than one frame and WebPs with
+// more than one ANMF chunk. Other types are never animated.
+func isAnimated(p string, imgType string) (bool, error) {
+	var count func(r *bufio.Reader) (int, error)
+	switch imgType {
+	case "gif":
+		count = gifFrames
+	case "png":
+		count = pngFrames
+	case "webp":
+		count = webpFrames
+	default:
+		return false, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	frames, err := count(bufio.NewReader(f))
+	return frames > 1, err
+}
+
+var errInvalidImage = errors.New("invalid image")
+
+// gifFrames counts the image descriptors of a GIF up to the second one
+func gifFrames(r *bufio.Reader) (int, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	// global color table
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << (header[10]&0x07 + 1)); err != nil {
+			return 0, err
+		}
+	}
+	frames := 0
+	for frames < 2 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return frames, err
+		}
+		switch b {
+		case 0x21: // extension: label and sub-blocks
+			if _, err := r.ReadByte(); err != nil {
+				return frames, err
+			}
+		case 0x2c: // image descriptor, optional local color table, LZW code size and sub-blocks
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return frames, err
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, err := r.Discard(3 << (descriptor[8]&0x07 + 1)); err != nil {
+					return frames, err
+				}
+			}
+			if _, err := r.ReadByte(); err != nil {
+				return frames, err
+			}
+			frames++
+		case 0x3b: // trailer
+			return frames, nil
+		default:
+			return frames, errInvalidImage
+		}
+		if err := skipGifSubBlocks(r); err != nil {
+			return frames, err
+		}
+	}
+	return frames, nil
+}
+
+func skipGifSubBlocks(r *bufio.Reader) error {
+	for {
+		n, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+// pngFrames returns the number of frames of the acTL chunk of an APNG, which comes before the image data, or 1
+func pngFrames(r *bufio.Reader) (int, error) {
+	if _, err := r.Discard(8); err != nil {
+		return 0, err
+	}
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, err
+		}
+		length := int(binary.BigEndian.Uint32(chunk[:4]))
+		switch string(chunk[4:]) {
+		case "acTL":
+			frames := make([]byte, 4)
+			if _, err := io.ReadFull(r, frames); err != nil {
+				return 0, err
+			}
+			return int(binary.BigEndian.Uint32(frames)), nil
+		case "IDAT", "IEND":
+			return 1, nil
+		}
+		// data and crc
+		if _, err := r.Discard(length + 4); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// webpFrames counts the ANMF chunks of a WebP up to the second one, still WebPs have none and count as 1
+func webpFrames(r *bufio.Reader) (int, error) {
+	if _, err := r.Discard(12); err != nil {
+		return 0, err
+	}
+	frames := 0
+	chunk := make([]byte, 8)
+	for frames < 2 {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return frames, err
+		}
+		if string(chunk[:4]) == "ANMF" {
+			frames++
+		}
+		length := int(binary.LittleEndian.Uint32(chunk[4:]))
+		// chunks are padded to an even size
+		if _, err := r.Discard(length + length%2); err != nil {
+			return frames, err
+		}
+	}
+	if frames == 0 {
+		frames = 1
+	}
+	return frames, nil
+}
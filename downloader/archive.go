@@ -0,0 +1,331 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// archive is a zip or tar file that is written to a temporary file and replaces path when it is closed.
+// Entries of an already existing archive at path are copied over on close, unless they were written again.
+type archive struct {
+	path    string
+	format  string
+	tmp     *os.File
+	zip     *zip.Writer
+	tar     *tar.Writer
+	entries map[string]struct{}
+	written map[string]struct{}
+}
+
+func ParseArchiveFormat(format string) (string, error) {
+	switch format {
+	case "", "zip", "tar":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown archive format %s, use zip or tar", format)
+}
+
+// archiveEntryName returns the name of the entry for the rendered path p, relative to the output root
+func (dl *Downloader) archiveEntryName(p string) string {
+	rel, err := filepath.Rel(dl.OutputRoot, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// absolute template paths outside the output root
+		rel = strings.TrimPrefix(p, filepath.VolumeName(p))
+	}
+	return strings.TrimLeft(filepath.ToSlash(rel), "/")
+}
+
+// openArchive returns the archive of the subreddit of submission, creating it on first use. Callers hold archivesMu.
+func (dl *Downloader) openArchive(submission Submission) (*archive, error) {
+	name := submission.Subreddit
+	if name == "" {
+		name = "archive"
+	}
+	if a, ok := dl.archives[name]; ok {
+		return a, nil
+	}
+	a := &archive{
+		path:    filepath.Join(dl.OutputRoot, name+"."+dl.ArchiveFormat),
+		format:  dl.ArchiveFormat,
+		entries: make(map[string]struct{}),
+		written: make(map[string]struct{}),
+	}
+	err := a.readEntries(func(name string, _ io.Reader) error {
+		a.entries[name] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.tmp, err = createTemp(dl.OutputRoot)
+	if err != nil {
+		return nil, err
+	}
+	if dl.ArchiveFormat == "zip" {
+		a.zip = zip.NewWriter(a.tmp)
+	} else {
+		a.tar = tar.NewWriter(a.tmp)
+	}
+	dl.archives[name] = a
+	return a, nil
+}
+
+// readEntries calls fn for every file in the existing archive at a.path, if there is one
+func (a *archive) readEntries(fn func(name string, r io.Reader) error) error {
+	if a.format == "zip" {
+		zr, err := zip.OpenReader(a.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, r)
+			_ = r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = fn(h.Name, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (a *archive) add(name string, r io.Reader, size int64) error {
+	if a.format == "zip" {
+		w, err := a.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := a.tar.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(a.tar, r)
+		if err != nil {
+			return err
+		}
+	}
+	a.entries[name] = struct{}{}
+	a.written[name] = struct{}{}
+	return nil
+}
+
+// close copies the entries of the existing archive that weren't replaced and moves the new archive in its place
+func (a *archive) close() error {
+	err := a.readEntries(func(name string, r io.Reader) error {
+		if _, ok := a.written[name]; ok {
+			return nil
+		}
+		if a.format == "zip" {
+			return a.add(name, r, 0)
+		}
+		// tar needs the size up front
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return a.add(name, strings.NewReader(string(data)), int64(len(data)))
+	})
+	if err == nil {
+		if a.zip != nil {
+			err = a.zip.Close()
+		} else {
+			err = a.tar.Close()
+		}
+	}
+	closeErr := a.tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(a.tmp.Name())
+		return err
+	}
+	return os.Rename(a.tmp.Name(), a.path)
+}
+
+// archiveHasEntry reports whether the archive of submission already contains the file p
+func (dl *Downloader) archiveHasEntry(submission Submission, p string) bool {
+	dl.archivesMu.Lock()
+	defer dl.archivesMu.Unlock()
+	a, err := dl.openArchive(submission)
+	if err != nil {
+		// writing will fail with the same error
+		return false
+	}
+	_, ok := a.entries[dl.archiveEntryName(p)]
+	return ok
+}
+
+// archiveFile adds the file src to the archive of submission as p and removes src
+func (dl *Downloader) archiveFile(submission Submission, p string, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(src)
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	dl.archivesMu.Lock()
+	defer dl.archivesMu.Unlock()
+	a, err := dl.openArchive(submission)
+	if err != nil {
+		return err
+	}
+	return a.add(dl.archiveEntryName(p), f, info.Size())
+}
+
+// archiveBytes adds data to the archive of submission as p
+func (dl *Downloader) archiveBytes(submission Submission, p string, data []byte) error {
+	dl.archivesMu.Lock()
+	defer dl.archivesMu.Unlock()
+	a, err := dl.openArchive(submission)
+	if err != nil {
+		return err
+	}
+	return a.add(dl.archiveEntryName(p), strings.NewReader(string(data)), int64(len(data)))
+}
+
+// outputExists reports whether p was already written, to the disk or to the archive of submission with -archive
+func (dl *Downloader) outputExists(submission Submission, p string) bool {
+	if dl.Stdout != nil {
+		return false
+	}
+	if dl.ArchiveFormat != "" {
+		return dl.archiveHasEntry(submission, p)
+	}
+	// anything but "not exist", including other errors
+	_, err := os.Stat(p)
+	return err == nil || !os.IsNotExist(err)
+}
+
+// saveDownload moves the download to p, or adds it to the archive of submission with -archive
+func (dl *Downloader) saveDownload(submission Submission, d *download, p string) error {
+	// taken only now, so waiting doesn't count against -image-timeout and skipped downloads don't wait
+	<-dl.writeThrottler
+	if dl.Stdout != nil {
+		f, err := os.Open(d.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return dl.writeStdout(p, f)
+	}
+	if dl.ArchiveFormat != "" {
+		return dl.archiveFile(submission, p, d.file)
+	}
+	_ = os.MkdirAll(filepath.Dir(p), os.ModeDir)
+	return d.moveTo(p)
+}
+
+// writeOutput writes data to p, or adds it to the archive of submission with -archive
+func (dl *Downloader) writeOutput(submission Submission, p string, data []byte) error {
+	<-dl.writeThrottler
+	if dl.Stdout != nil {
+		return dl.writeStdout(p, bytes.NewReader(data))
+	}
+	if dl.ArchiveFormat != "" {
+		return dl.archiveBytes(submission, p, data)
+	}
+	_ = os.MkdirAll(filepath.Dir(p), os.ModeDir)
+	if err := ioutil.WriteFile(p, data, os.ModePerm); err != nil {
+		return err
+	}
+	dl.setModTime(p, postTime(submission))
+	return nil
+}
+
+// writeStdout copies the file that would be written to p to Stdout, which only takes a single file
+func (dl *Downloader) writeStdout(p string, r io.Reader) error {
+	if dl.stdoutWritten {
+		return fmt.Errorf("not writing %s, -stdout takes only one file", p)
+	}
+	dl.stdoutWritten = true
+	_, err := io.Copy(dl.Stdout, r)
+	return err
+}
+
+// closeArchives finishes all archives, further writes open new ones
+func (dl *Downloader) closeArchives() {
+	dl.archivesMu.Lock()
+	defer dl.archivesMu.Unlock()
+	for name, a := range dl.archives {
+		err := a.close()
+		if err != nil {
+			dl.LogFailure("closing archive %s => %v", a.path, err)
+		} else {
+			for _, root := range dl.ExtraOutputRoots {
+				dst := filepath.Join(root, filepath.Base(a.path))
+				if err := dl.mirrorOne(a.path, dst); err != nil {
+					dl.LogFailure("mirroring archive %s => %s: %v", a.path, dst, err)
+				}
+			}
+		}
+		delete(dl.archives, name)
+	}
+}
+
+// CloseArchivesOnSignal closes the archives before exiting on SIGINT and SIGTERM, so they aren't left unfinished
+func (dl *Downloader) CloseArchivesOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-c
+		log.Printf("received %v, closing archives", s)
+		dl.closeArchives()
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checksumsFile is the name of the aggregate file of -checksums sums, one per directory
+const checksumsFile = "SHA256SUMS"
+
+// ParseChecksums checks a -checksums mode, sidecar writes <file>.sha256 and sums appends to SHA256SUMS in the directory of the file
+func ParseChecksums(mode string) (string, error) {
+	switch mode {
+	case "", "sidecar", "sums":
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown checksums mode %s, use sidecar or sums", mode)
+}
+
+// writeChecksum writes the sha256 of the file p in the format of sha256sum, so `sha256sum -c` can verify it
+// from the directory of p. With sums, the line is also appended in the extra output roots p is mirrored to.
+func (dl *Downloader) writeChecksum(submission Submission, p string, sum string) {
+	if dl.Checksums == "" {
+		return
+	}
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString([]byte(sum)), filepath.Base(p))
+	if dl.Checksums == "sidecar" {
+		err := dl.writeOutput(submission, p+".sha256", []byte(line))
+		if err != nil {
+			dl.LogFailure("writing checksum %s.sha256 => %v", p, err)
+		}
+		return
+	}
+
+	dirs := []string{filepath.Dir(p)}
+	if rel, ok := dl.mirrorPath(p); ok {
+		for _, root := range dl.ExtraOutputRoots {
+			dirs = append(dirs, filepath.Dir(filepath.Join(root, rel)))
+		}
+	}
+	for _, dir := range dirs {
+		err := appendChecksum(filepath.Join(dir, checksumsFile), line)
+		if err != nil {
+			dl.LogFailure("writing checksum of %s to %s => %v", p, filepath.Join(dir, checksumsFile), err)
+		}
+	}
+}
+
+func appendChecksum(p string, line string) error {
+	_ = os.MkdirAll(filepath.Dir(p), os.ModeDir)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(line)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkSubmissionPaths renders the path templates for a submission without downloading any images.
+// Album contents are fetched from imgur to render the album template.
+func (dl *Downloader) checkSubmissionPaths(submission Submission) {
+	u, err := url.Parse(submission.Url)
+	if err != nil {
+		log.Printf("invalid url: %s", submission.Url)
+		return
+	}
+	if submission.Domain == "imgur.com" && strings.HasPrefix(u.Path, "/a/") {
+		album, err := dl.imgurClient.GetAlbum(strings.TrimPrefix(u.Path, `/a/`))
+		if err != nil {
+			log.Printf("fetching imgur album: %s (%s) => %v", submission.Url, submission.Permalink, err)
+			return
+		}
+		for i, img := range album.Images {
+			p := dl.renderAlbumPath(submission, img, i+1, len(album.Images), img.Ext, time.Time{})
+			dl.templatePaths[p] = append(dl.templatePaths[p], submission.Permalink)
+		}
+		return
+	}
+
+	ext := path.Ext(u.Path)
+	if ext == "" && submission.Domain == "imgur.com" {
+		ext = imgurExtensions[0]
+	}
+	p := dl.renderSinglePath(submission, submission.Url, ext, time.Time{})
+	dl.templatePaths[p] = append(dl.templatePaths[p], submission.Permalink)
+}
+
+// ReportTemplateCollisions logs every path that more than one image would be written to
+// and returns the number of colliding paths.
+func (dl *Downloader) ReportTemplateCollisions() int {
+	var collisions []string
+	for p, sources := range dl.templatePaths {
+		if len(sources) > 1 {
+			collisions = append(collisions, p)
+		}
+	}
+	sort.Strings(collisions)
+	for _, p := range collisions {
+		log.Printf("collision: %s <= %s", p, strings.Join(dl.templatePaths[p], ", "))
+	}
+	log.Printf("checked %d paths, %d collisions", len(dl.templatePaths), len(collisions))
+	return len(collisions)
+}
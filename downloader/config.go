@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"text/template"
+)
+
+// Config is the json file of -config. It holds settings for single targets that override the global options.
+type Config struct {
+	Targets []TargetConfig `json:"targets"`
+}
+
+type TargetConfig struct {
+	Target string `json:"target"`
+	// Search replaces -search
+	Search string `json:"search"`
+	// Flair is a case insensitive regular expression the link flair has to match
+	Flair string `json:"flair"`
+	// MinScore replaces -min-score if it is set
+	MinScore *int `json:"min_score"`
+	// Filter is a filter expression in addition to -filter
+	Filter         string `json:"filter"`
+	SingleTemplate string `json:"single_template"`
+	AlbumTemplate  string `json:"album_template"`
+
+	flair          *regexp.Regexp
+	filter         *Filter
+	singleTemplate *template.Template
+	albumTemplate  *template.Template
+}
+
+// ReadConfig reads and compiles a -config file and returns the targets in it.
+func (dl *Downloader) ReadConfig(p string) ([]string, error) {
+	configs, targets, err := ReadConfigFile(p)
+	if err != nil {
+		return nil, err
+	}
+	dl.configMu.Lock()
+	defer dl.configMu.Unlock()
+	for target, c := range configs {
+		dl.TargetConfigs[target] = c
+	}
+	return targets, nil
+}
+
+// ReadConfigFile reads and compiles a -config file and returns the settings by target and the targets in it,
+// without touching the TargetConfigs of a Downloader.
+func ReadConfigFile(p string) (map[string]*TargetConfig, []string, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	var config Config
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configs := make(map[string]*TargetConfig)
+
+	var targets []string
+	for i := range config.Targets {
+		c := &config.Targets[i]
+		target, err := NormalizeTarget(c.Target)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.Flair != "" {
+			c.flair, err = regexp.Compile("(?i)" + c.Flair)
+			if err != nil {
+				return nil, nil, fmt.Errorf("flair of %s: %v", c.Target, err)
+			}
+		}
+		if c.Filter != "" {
+			c.filter, err = CompileFilter(c.Filter)
+			if err != nil {
+				return nil, nil, fmt.Errorf("filter of %s: %v", c.Target, err)
+			}
+		}
+		if c.SingleTemplate != "" {
+			c.singleTemplate, err = template.New("name").Funcs(template.FuncMap{"slugify": slugify}).Parse(c.SingleTemplate)
+			if err != nil {
+				return nil, nil, fmt.Errorf("single template of %s: %v", c.Target, err)
+			}
+		}
+		if c.AlbumTemplate != "" {
+			c.albumTemplate, err = template.New("name").Funcs(template.FuncMap{"slugify": slugify}).Parse(c.AlbumTemplate)
+			if err != nil {
+				return nil, nil, fmt.Errorf("album template of %s: %v", c.Target, err)
+			}
+		}
+		configs[target] = c
+		targets = append(targets, target)
+	}
+	return configs, targets, nil
+}
+
+// targetConfig returns the settings of a target, nil if it has none
+func (dl *Downloader) targetConfig(target string) *TargetConfig {
+	dl.configMu.RLock()
+	defer dl.configMu.RUnlock()
+	return dl.TargetConfigs[target]
+}
+
+// targetFiltersUseImage reports whether the filter of any target references image fields
+func (dl *Downloader) targetFiltersUseImage() bool {
+	dl.configMu.RLock()
+	defer dl.configMu.RUnlock()
+	for _, c := range dl.TargetConfigs {
+		if c.filter != nil && c.filter.usesImage {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTargetConfig checks a submission against the settings of its target that don't need the image.
+// minScore is the global -min-score. Submissions of targets without settings always match.
+func (dl *Downloader) matchTargetConfig(submission Submission, minScore int) (bool, string) {
+	c := dl.targetConfig(submission.Target)
+	if c == nil {
+		return true, ""
+	}
+	if c.MinScore != nil {
+		minScore = *c.MinScore
+	}
+	if submission.Score < minScore {
+		return false, fmt.Sprintf("score below %d (has %d)", minScore, submission.Score)
+	}
+	if c.flair != nil && !c.flair.MatchString(submission.LinkFlairText) {
+		return false, fmt.Sprintf("flair %q mismatch", submission.LinkFlairText)
+	}
+	if c.filter != nil && !c.filter.usesImage && !c.filter.Match(submission, 0, 0) {
+		return false, "filter mismatch"
+	}
+	return true, ""
+}
+
+// matchTargetVideo checks a submission against the filter of its target for a download without dimensions,
+// filters that need the image fields to decide don't match
+func (dl *Downloader) matchTargetVideo(submission Submission) bool {
+	c := dl.targetConfig(submission.Target)
+	return c == nil || c.filter == nil || !c.filter.usesImage || c.filter.MatchWithoutImage(submission)
+}
+
+// matchTargetImage checks a submission against the filter of its target, if it references image fields
+func (dl *Downloader) matchTargetImage(submission Submission, width int, height int) bool {
+	c := dl.targetConfig(submission.Target)
+	return c == nil || c.filter == nil || !c.filter.usesImage || c.filter.Match(submission, width, height)
+}
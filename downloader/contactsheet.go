@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// DefaultContactSheetCell is the default size of the square cells of -album-contact-sheet in pixels
+const DefaultContactSheetCell = 300
+
+// fetchContactSheet downloads the images of an album and writes them as one JPEG grid with ContactSheetColumns
+// columns instead of separate files, at the path of -single-template. Every image is scaled to fit into a square cell,
+// images that can't be decoded (e.g. videos), that don't pass the image filters, are duplicates or are gone are left out.
+// The sheet isn't written if an image failed to download, so the album is fetched again by the next run.
+func (dl *Downloader) fetchContactSheet(submission Submission, images []AlbumImage) error {
+	p := dl.renderSinglePath(submission, submission.Url, ".jpg", time.Time{})
+	if !dl.Overwrite {
+		if dl.outputExists(submission, p) {
+			dl.logSkip(submission, submission.Url, "file exists", "fetching %s (%s) => file exists, overwrite disabled", submission.Url, submission.Permalink)
+			return nil
+		}
+	}
+
+	if dl.AlbumLimit > 0 && len(images) > dl.AlbumLimit {
+		images = images[:dl.AlbumLimit]
+	}
+	cell := dl.ContactSheetCell
+	if cell <= 0 {
+		cell = DefaultContactSheetCell
+	}
+	columns := dl.ContactSheetColumns
+	if columns > len(images) {
+		columns = len(images)
+	}
+	if columns == 0 {
+		return nil
+	}
+	rows := (len(images) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cell, rows*cell))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	placed, skipped, failed := 0, 0, 0
+	// of the placed images, remembered once the sheet is written
+	var hashes, fingerprints []string
+	for _, img := range images {
+		decoded, hash, fp, err := dl.getContactSheetImage(submission, albumImageUrl(img))
+		if err == errImageNotFound {
+			// gone for good, fetching the album again wouldn't bring it back
+			continue
+		} else if err != nil {
+			failed++
+			continue
+		} else if decoded == nil {
+			skipped++
+			continue
+		}
+		hashes = append(hashes, hash)
+		fingerprints = append(fingerprints, fp)
+		// fit into the cell keeping the aspect ratio, centered
+		b := decoded.Bounds()
+		w, h := cell, cell
+		if b.Dx() > b.Dy() {
+			h = cell * b.Dy() / b.Dx()
+		} else {
+			w = cell * b.Dx() / b.Dy()
+		}
+		x := (placed%columns)*cell + (cell-w)/2
+		y := (placed/columns)*cell + (cell-h)/2
+		draw.BiLinear.Scale(sheet, image.Rect(x, y, x+w, y+h), decoded, b, draw.Over, nil)
+		placed++
+	}
+	if failed > 0 {
+		// a sheet without them would be skipped as existing by the next run, so they could never be retried
+		log.Printf("fetching contact sheet %s (%s) => %d images failed, not writing it", submission.Url, submission.Permalink, failed)
+		return fmt.Errorf("%d images of the contact sheet failed", failed)
+	}
+	if placed == 0 && skipped > 0 {
+		dl.logSkip(submission, submission.Url, "no images passed the filters", "fetching contact sheet %s (%s) => no images passed the filters, skipping", submission.Url, submission.Permalink)
+		return nil
+	} else if placed == 0 {
+		dl.logFetchFailure(submission, submission.Url, "no images", "fetching contact sheet %s (%s) => no images", submission.Url, submission.Permalink)
+		return errImageNotFound
+	}
+	// drop the rows of images that were left out
+	used := sheet.SubImage(image.Rect(0, 0, columns*cell, (placed+columns-1)/columns*cell))
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, used, &jpeg.Options{Quality: 90})
+	if err != nil {
+		return err
+	}
+	d, err := dl.streamDownload(&buf)
+	if err != nil {
+		dl.logFetchFailure(submission, submission.Url, err.Error(), "fetching contact sheet %s (%s) => %v", submission.Url, submission.Permalink, err)
+		return err
+	}
+	defer d.discard()
+	err = dl.saveDownload(submission, d, p)
+	if err != nil {
+		dl.logFetchFailure(submission, submission.Url, err.Error(), "fetching contact sheet %s (%s) => %v", submission.Url, submission.Permalink, err)
+		return err
+	}
+	dl.setModTime(p, postTime(submission))
+	dl.countDownload(submission, d.size)
+	dl.rememberHash(d.hash)
+	for i := range hashes {
+		dl.rememberHash(hashes[i])
+		dl.rememberFingerprint(fingerprints[i], "")
+	}
+	dl.writeRawMetadata(submission, p)
+	dl.writeChecksum(submission, p, d.sum)
+	dl.mirrorFile(p, submission.Url, submission)
+	dl.addGalleryEntry(submission, p)
+	dl.logSave(saveEvent(submission, submission.Url, p, d), "fetching contact sheet %s (%s) => %s (%d of %d images)", submission.Url, submission.Permalink, p, placed, len(images))
+	return nil
+}
+
+// getContactSheetImage downloads and decodes an album image for a contact sheet with fetchChecked, which applies the
+// same filters and duplicate detection as to the images of fetchAlbumImage. decoded is nil and err is nil for
+// rejected images, hash and fp are those of the download for remembering it. Videos can't be decoded and are skipped.
+func (dl *Downloader) getContactSheetImage(submission Submission, u string) (decoded image.Image, hash string, fp string, err error) {
+	c, err := dl.fetchChecked(imageFetch{
+		u:          u,
+		submission: submission,
+		dedupe:     dl.SkipDuplicatesInAlbums,
+	})
+	if c == nil {
+		return nil, "", "", err
+	}
+	defer c.discard()
+
+	f, err := os.Open(c.file)
+	if err != nil {
+		dl.logFetchFailure(submission, u, err.Error(), "fetching %s (%s) => %v", u, submission.Permalink, err)
+		return nil, "", "", err
+	}
+	defer f.Close()
+	decoded, _, err = image.Decode(f)
+	if err != nil {
+		dl.logSkip(submission, u, "can't be decoded", "fetching %s (%s) => can't be decoded, leaving it out of the contact sheet", u, submission.Permalink)
+		return nil, "", "", nil
+	}
+	return decoded, c.hash, c.fp, nil
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestContactSheetFiltersImages(t *testing.T) {
+	landscape := pngBytes(t, 400, 200)
+	files := map[string][]byte{
+		"/landscape.png": landscape,
+		"/portrait.png":  pngBytes(t, 200, 400),
+		"/copy.png":      landscape,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "contactsheet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dl := New()
+	dl.OutputRoot = dir
+	dl.ContactSheetColumns = 4
+	dl.ContactSheetCell = 10
+	dl.SkipDuplicatesInAlbums = true
+	dl.SetOrientations([]string{"landscape"})
+	var skips []string
+	var saved DownloadEvent
+	dl.OnDownload = func(e DownloadEvent) {
+		if e.Skipped {
+			skips = append(skips, e.Url[len(server.URL):]+" "+e.Reason)
+		} else {
+			saved = e
+		}
+	}
+
+	var submission Submission
+	submission.Id = "sheet"
+	submission.Subreddit = "pics"
+	submission.Url = "https://imgur.com/a/sheet"
+	var images []AlbumImage
+	for _, name := range []string{"landscape", "portrait", "copy", "missing"} {
+		images = append(images, AlbumImage{Hash: name, Ext: ".png", Url: server.URL + "/" + name + ".png"})
+	}
+	if err := dl.fetchContactSheet(submission, images); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(skips)
+	if strings.Join(skips, ",") != "/copy.png duplicate,/portrait.png portrait orientation" {
+		t.Errorf("skipped %v, want the portrait image and the copy", skips)
+	}
+	// only the landscape image is placed, in the first of 4 columns
+	if saved.Path == "" || saved.Width != 40 || saved.Height != 10 {
+		t.Errorf("saved %+v, want a sheet of one row", saved)
+	}
+}
+
+func TestContactSheetNotWrittenOnFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken.png" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(pngBytes(t, 400, 200))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "contactsheet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dl := New()
+	dl.OutputRoot = dir
+	dl.ContactSheetColumns = 2
+	var saved []string
+	dl.OnDownload = func(e DownloadEvent) {
+		if !e.Skipped {
+			saved = append(saved, e.Path)
+		}
+	}
+
+	var submission Submission
+	submission.Id = "sheet"
+	submission.Subreddit = "pics"
+	submission.Url = "https://imgur.com/a/sheet"
+	images := []AlbumImage{{Hash: "ok", Ext: ".png", Url: server.URL + "/ok.png"}, {Hash: "broken", Ext: ".png", Url: server.URL + "/broken.png"}}
+	if err := dl.fetchContactSheet(submission, images); err == nil {
+		t.Error("no error for a sheet with a failed image")
+	}
+	if len(saved) != 0 {
+		t.Errorf("saved %v, want no sheet so the album is fetched again", saved)
+	}
+	if dl.Failures() != 1 {
+		t.Errorf("%d failures, want the failed image", dl.Failures())
+	}
+}
@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadCookieFile reads the reddit.com cookies of a cookie file in the Netscape format (as exported by browser extensions
+// or curl -c) into a cookie jar. Cookies of other domains and expired ones are ignored.
+func ReadCookieFile(p string) (http.CookieJar, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	found := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		// curl marks HttpOnly cookies with a prefix that would otherwise make them comments
+		text = strings.TrimPrefix(text, "#HttpOnly_")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab separated fields, got %d", line, len(fields))
+		}
+		domain, subdomains, cookiePath, secure, expires, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+		host := strings.TrimPrefix(domain, ".")
+		if host != "reddit.com" && !strings.HasSuffix(host, ".reddit.com") {
+			continue
+		}
+		cookie := &http.Cookie{
+			Name:   name,
+			Value:  value,
+			Path:   cookiePath,
+			Secure: strings.EqualFold(secure, "TRUE"),
+		}
+		if strings.EqualFold(subdomains, "TRUE") {
+			cookie.Domain = host
+		}
+		if expires != "0" {
+			seconds, err := strconv.ParseInt(expires, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid expiry %q", line, expires)
+			}
+			cookie.Expires = time.Unix(seconds, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: cookiePath}, []*http.Cookie{cookie})
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("no unexpired reddit.com cookies in %s", p)
+	}
+	return jar, nil
+}
+
+// SetRedditCookies sends the cookies of jar with every reddit request, e.g. a reddit_session cookie to list
+// private subreddits. They are never sent to imgur or image hosts.
+func (dl *Downloader) SetRedditCookies(jar http.CookieJar) {
+	dl.redditClient.cookies = jar
+}
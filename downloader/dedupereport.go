@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+type DedupeSource struct {
+	Url       string `json:"url"`
+	Permalink string `json:"permalink"`
+}
+
+type DedupeGroup struct {
+	Sha256  string         `json:"sha256"`
+	Sources []DedupeSource `json:"sources"`
+}
+
+// recordDedupeSource remembers that u resolved to the content with the given hash.
+func (dl *Downloader) recordDedupeSource(hash string, u string, submission Submission) {
+	if dl.DedupeReport == "" {
+		return
+	}
+	for _, s := range dl.dedupeSources[hash] {
+		if s.Url == u && s.Permalink == submission.Permalink {
+			return
+		}
+	}
+	dl.dedupeSources[hash] = append(dl.dedupeSources[hash], DedupeSource{Url: u, Permalink: submission.Permalink})
+}
+
+// writeDedupeReport writes the hashes that more than one url or submission resolved to,
+// the most common ones first.
+func (dl *Downloader) writeDedupeReport() error {
+	groups := make([]DedupeGroup, 0)
+	for hash, sources := range dl.dedupeSources {
+		if len(sources) < 2 {
+			continue
+		}
+		// pixel hashes of -dedupe-pixels keep their prefix
+		prefix := ""
+		if strings.HasPrefix(hash, pixelHashPrefix) {
+			prefix = pixelHashPrefix
+			hash = hash[len(pixelHashPrefix):]
+		}
+		groups = append(groups, DedupeGroup{Sha256: prefix + hex.EncodeToString([]byte(hash)), Sources: sources})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Sources) != len(groups[j].Sources) {
+			return len(groups[i].Sources) > len(groups[j].Sources)
+		}
+		return groups[i].Sha256 < groups[j].Sha256
+	})
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dl.DedupeReport, data, 0644)
+}
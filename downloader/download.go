@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// downloadHeaderSize is how much of a download is kept in memory for checkImage,
+// which is enough for the headers of all supported formats
+const downloadHeaderSize = heifHeaderLimit
+
+// download is a response body that was streamed to a temporary file
+type download struct {
+	file string
+	// header holds the first downloadHeaderSize bytes
+	header []byte
+	size   int
+	// hash is the binary sha256 of the content, or its pixel hash with -dedupe-pixels
+	hash string
+	// sum is the binary sha256 of the file, which changes with -auto-orient
+	sum string
+}
+
+// headerWriter keeps the first limit bytes written to it
+type headerWriter struct {
+	data  []byte
+	limit int
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if rest := h.limit - len(h.data); rest > 0 {
+		if len(p) < rest {
+			rest = len(p)
+		}
+		h.data = append(h.data, p[:rest]...)
+	}
+	return len(p), nil
+}
+
+// streamDownload writes r to a temporary file in the output root (or the system's temp directory for runs that don't write files)
+// while hashing it, so memory use doesn't depend on the size of the download.
+func (dl *Downloader) streamDownload(r io.Reader) (*download, error) {
+	dir := dl.OutputRoot
+	if !dl.WritesFiles() {
+		dir = os.TempDir()
+	}
+	f, err := createTemp(dir)
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	header := &headerWriter{limit: downloadHeaderSize}
+	n, err := io.Copy(io.MultiWriter(f, hasher, header), r)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	d := &download{
+		file:   f.Name(),
+		header: header.data,
+		size:   int(n),
+		hash:   string(hasher.Sum(nil)),
+	}
+	d.sum = d.hash
+	if dl.DedupePixels {
+		if hash, ok := pixelHash(d.file); ok {
+			d.hash = hash
+		}
+	}
+	return d, nil
+}
+
+// createTemp creates a new hidden file in dir with the same permissions ioutil.WriteFile(p, data, os.ModePerm) would use
+func createTemp(dir string) (*os.File, error) {
+	for {
+		p := filepath.Join(dir, fmt.Sprintf(".download-%d-%d.part", os.Getpid(), rand.Int63()))
+		f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, os.ModePerm)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+}
+
+// moveTo moves the downloaded file to p, copying it if it is on another file system
+func (d *download) moveTo(p string) error {
+	err := os.Rename(d.file, p)
+	if err == nil {
+		return nil
+	}
+	src, err := os.Open(d.file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	_ = src.Close()
+	return os.Remove(d.file)
+}
+
+// discard removes the temporary file, if it wasn't moved
+func (d *download) discard() {
+	_ = os.Remove(d.file)
+}
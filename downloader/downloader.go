@@ -0,0 +1,1621 @@
+// Package downloader downloads the images of reddit submissions, it is used by the reddit-image-downloader command.
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/gosimple/slug"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// DefaultSingleTemplate and DefaultAlbumTemplate are the default path templates, DefaultSingleTemplate is also used for self posts
+const DefaultSingleTemplate = `{{.Submission.Subreddit}}/{{.Timestamp}}-{{.Submission.Id}}-{{.Submission.Title | slugify}}{{.Ext}}`
+const DefaultAlbumTemplate = `{{.Submission.Subreddit}}/{{.Timestamp}}-{{.Submission.Id}}-{{.Submission.Title | slugify}}/{{.Num}}-{{.Image.Hash}}{{.Ext}}`
+
+// Downloader filters submissions and downloads their images. The exported fields are its settings,
+// they correspond to the command line options and must not be changed while submissions are handled.
+// A Downloader handles one submission at a time, except for the listings fetched by its Listers.
+type Downloader struct {
+	OutputRoot string
+	// ExtraOutputRoots receive copies of every written file
+	ExtraOutputRoots []string
+
+	SingleTemplate *template.Template
+	AlbumTemplate  *template.Template
+	TextTemplate   *template.Template
+
+	// HttpClient is used for the reddit and imgur apis
+	HttpClient http.Client
+	// ImageClient has no timeout of its own, image downloads are bounded by ImageTimeout
+	ImageClient  http.Client
+	ImageTimeout time.Duration
+
+	// Nsfw includes nsfw submissions
+	Nsfw bool
+	// MaxAge skips submissions created longer ago, 0 = off
+	MaxAge time.Duration
+	// MinScore applies to submissions of targets without a TargetConfig
+	MinScore       int
+	NoCrossposts   bool
+	OnlyCrossposts bool
+	OnlyOc         bool
+	// TargetConfigs holds the settings per target of ReadConfig
+	TargetConfigs map[string]*TargetConfig
+	// SearchSort and SearchTime apply to the search of Listers
+	SearchSort string
+	SearchTime string
+	// DomainSort and DomainTime apply to domain:<domain> targets
+	DomainSort string
+	DomainTime string
+	// Geo is sent as geo_filter with every listing request, see ParseGeo
+	Geo string
+
+	NoAlbums                   bool
+	Embeds                     bool
+	PreferMp4                  bool
+	MinAlbumImages             int
+	MaxAlbumImages             int
+	AlbumLimit                 int
+	SingleImageAlbumsAsSingles bool
+	SaveText                   bool
+	// Prefer is the order of extensions (without dot) in which the formats of the same image are tried,
+	// e.g. the extensions of imgur links, an animated album image and its mp4, or an image and its preview
+	Prefer []string
+	// Unblur downloads the largest unblurred preview of spoiler image posts instead of their url, which can be blurred
+	Unblur bool
+
+	SkipDuplicates         bool
+	SkipDuplicatesInAlbums bool
+	HardlinkDuplicates     bool
+	ExcludeAlreadyLinked   bool
+	DedupePixels           bool
+	// FastDedup detects duplicates before downloading them by a fingerprint of their size and first
+	// FastDedupBytes, fetched with a range request. Different images with the same start and size are taken for duplicates.
+	FastDedup      bool
+	FastDedupBytes int
+	DedupeTitles   bool
+	// TitleNormalization is slug, lower or exact
+	TitleNormalization string
+	// TitlesFile persists the seen titles across runs, one per line, "" if off
+	TitlesFile string
+	// HashesFile persists the hashes of written files across runs for the duplicate detection, "" if off
+	HashesFile string
+	// OnlyNewHashes downloads and filters images like a normal run but only adds the hashes of new ones
+	// to HashesFile instead of writing them, see WritesFiles
+	OnlyNewHashes bool
+	// DedupeWindow forgets titles that weren't seen for this long, 0 keeps them forever
+	DedupeWindow time.Duration
+	// DedupeReport is the path of the dedupe report, "" if off
+	DedupeReport string
+	// AcceptQuarantine opts in to quarantined subreddits with the session of the reddit cookies before listing them
+	AcceptQuarantine bool
+	// SanitizePaths makes rendered paths valid on every OS, see sanitizePath
+	SanitizePaths bool
+	// ContactSheetColumns writes albums as one grid image with this many columns instead of separate files (0 = off),
+	// ContactSheetCell is the size of its square cells in pixels
+	ContactSheetColumns int
+	ContactSheetCell    int
+	// PreferSourceComment downloads the first image link in a top-level comment of the author of image posts
+	// instead of the posted image, which is often a smaller copy of the source. It costs a request per post.
+	PreferSourceComment bool
+	// PreserveTime sets the modification time of written files to the post time, or the imgur upload time of album images
+	PreserveTime bool
+	// UserSubredditMap files the submissions of user feeds under the subreddit u_<name> after filtering,
+	// so they are written to one folder per user instead of one per subreddit they were posted to
+	UserSubredditMap bool
+	// RequireDecodable skips downloads whose type and dimensions can't be read, even without image filters
+	RequireDecodable bool
+	// StaticOnly skips animated images (GIF, APNG, WebP with more than one frame) and videos
+	StaticOnly bool
+	// FailuresFile is where Finish writes the failed downloads for ReadFailuresFile, "" if off
+	FailuresFile string
+
+	MinWidth  int
+	MaxWidth  int
+	MinHeight int
+	MaxHeight int
+	MaxAspect float64
+
+	NoPortrait  bool
+	NoLandscape bool
+	NoSquare    bool
+	// SquareTolerance is how much longer than the short side the long side of a square image may be, e.g. 0.05 for 5%
+	SquareTolerance float64
+
+	MinSize      int
+	MaxSize      int
+	MinSizeTypes map[string]int
+	MaxSizeTypes map[string]int
+	// AllowTypes holds the allowed image package format names, all are allowed if it is empty
+	AllowTypes map[string]struct{}
+	// AllowContentTypes holds media types, "image/*" style wildcards are allowed
+	AllowContentTypes []string
+	Filter            *Filter
+	SkipScreenshots   bool
+	ScreenshotRules   ScreenshotRules
+
+	Overwrite   bool
+	MetadataRaw bool
+	AutoOrient  bool
+	Gallery     bool
+	// GifToMp4 transcodes downloaded GIFs to mp4 with ffmpeg, GIFs are kept if it isn't installed
+	GifToMp4 bool
+	// ArchiveFormat is "zip" or "tar" to write the files of each subreddit into an archive instead of to disk
+	ArchiveFormat string
+	// Checksums is "sidecar" or "sums" to write the sha256 of every downloaded file, see ParseChecksums
+	Checksums string
+	// Stdout receives the only written file instead of the output root, writing a second file fails
+	Stdout io.Writer
+	// MinFreeSpace is the space in bytes CheckFreeSpace requires on the output roots, 0 = off
+	MinFreeSpace int
+	// Probe downloads and decodes images without writing them and collects statistics
+	Probe bool
+	// CheckTemplate renders the paths of submissions without downloading them
+	CheckTemplate bool
+
+	// OnDownload is called with every written file and every skipped submission or image,
+	// from the goroutine that handles the submission. Nothing is logged for them if it is nil.
+	OnDownload func(DownloadEvent)
+
+	redditClient RedditClient
+	imgurClient  ImgurClient
+
+	throttler <-chan time.Time
+	// stopThrottle stops whatever feeds throttler
+	stopThrottle func()
+	// adaptiveThrottle is only set by SetAdaptiveThrottle and replaces the fixed ticker
+	adaptiveThrottle *AdaptiveThrottle
+	// writeThrottler spaces out file writes, see SetWriteThrottle
+	writeThrottler    <-chan time.Time
+	stopWriteThrottle func()
+	pause             *pauser
+	// runCtx ends at the deadline of SetMaxRuntime and cancels running image downloads
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+
+	// known urls and content hashes with the path they were written to (empty if not written)
+	knownUrls   map[string]string
+	knownHashes map[string]string
+	// imgur image hashes seen in single links and albums, for ExcludeAlreadyLinked
+	knownImgurHashes map[string]struct{}
+	// normalized titles with the time they were last seen
+	seenTitles map[string]time.Time
+	// hashes of HashesFile with the time they were last seen
+	seenHashes map[string]time.Time
+	// sources per (binary) sha256 hash, in download order
+	dedupeSources map[string][]DedupeSource
+
+	failures      int
+	newHashes     int
+	stdoutWritten bool
+	// ffmpeg is the path of ffmpeg for GifToMp4, looked up once
+	ffmpeg        string
+	ffmpegChecked bool
+	// request latencies per host, nil unless EnableHostTimings was called (guarded by statsMu)
+	hostTimings map[string]*hostTiming
+	// failed downloads with their submissions, for FailuresFile
+	failedDownloads []FailedDownload
+
+	// rendered paths and the urls that would be written to them, for CheckTemplate
+	templatePaths     map[string][]string
+	galleryEntries    map[string][]GalleryEntry
+	probeTypes        map[string]int
+	probeOrientations map[string]int
+	probeResolutions  map[string]int
+
+	// archives by subreddit, guarded by archivesMu because they are also closed by CloseArchivesOnSignal
+	archives   map[string]*archive
+	archivesMu sync.Mutex
+
+	// configMu guards TargetConfigs, which Lister.SetTargetConfigs replaces on SIGHUP
+	configMu sync.RWMutex
+
+	statsMu sync.Mutex
+	stats   Stats
+}
+
+// New returns a Downloader with the defaults of the command line options.
+func New() *Downloader {
+	dl := &Downloader{
+		OutputRoot:         ".",
+		SingleTemplate:     template.Must(ParseTemplate(DefaultSingleTemplate)),
+		AlbumTemplate:      template.Must(ParseTemplate(DefaultAlbumTemplate)),
+		TextTemplate:       template.Must(ParseTemplate(DefaultSingleTemplate)),
+		HttpClient:         http.Client{Timeout: 10 * time.Second},
+		ImageTimeout:       10 * time.Second,
+		TargetConfigs:      make(map[string]*TargetConfig),
+		SearchSort:         "new",
+		DomainSort:         "new",
+		SkipDuplicates:     true,
+		TitleNormalization: "slug",
+		MinSizeTypes:       make(map[string]int),
+		MaxSizeTypes:       make(map[string]int),
+		AllowTypes:         make(map[string]struct{}),
+		throttler:          unthrottled(),
+		stopThrottle:       func() {},
+		writeThrottler:     unthrottled(),
+		stopWriteThrottle:  func() {},
+		runCtx:             context.Background(),
+		cancelRun:          func() {},
+		pause:              newPauser(),
+		knownUrls:          make(map[string]string),
+		knownHashes:        make(map[string]string),
+		knownImgurHashes:   make(map[string]struct{}),
+		seenTitles:         make(map[string]time.Time),
+		seenHashes:         make(map[string]time.Time),
+		dedupeSources:      make(map[string][]DedupeSource),
+		templatePaths:      make(map[string][]string),
+		galleryEntries:     make(map[string][]GalleryEntry),
+		probeTypes:         make(map[string]int),
+		probeOrientations:  make(map[string]int),
+		probeResolutions:   make(map[string]int),
+		archives:           make(map[string]*archive),
+		stats: Stats{
+			Started:     time.Now(),
+			Targets:     make(map[string]TargetStats),
+			SkipReasons: make(map[string]int),
+			Subreddits:  make(map[string]SubredditStats),
+		},
+	}
+	dl.ScreenshotRules, _ = ParseScreenshotRules(DefaultScreenshotRules)
+	// the clients see changes to HttpClient, e.g. a proxy transport
+	dl.redditClient = RedditClient{http: &dl.HttpClient}
+	dl.imgurClient = ImgurClient{http: &dl.HttpClient}
+	return dl
+}
+
+// ParseTemplate parses a path template, which can use the slugify function
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("name").Funcs(template.FuncMap{"slugify": slugify}).Parse(text)
+}
+
+// SetThrottle makes Listers wait at least interval between requests to the reddit api, with up to burst requests at once.
+// An interval <= 0 disables throttling.
+func (dl *Downloader) SetThrottle(interval time.Duration, burst int) {
+	dl.stopThrottle()
+	dl.adaptiveThrottle = nil
+	if interval <= 0 {
+		dl.throttler = unthrottled()
+		dl.stopThrottle = func() {}
+		return
+	}
+	ticker := newImmediateTicker(interval, burst)
+	dl.throttler = ticker.C
+	dl.stopThrottle = ticker.Stop
+}
+
+// SetAdaptiveThrottle replaces the throttle with one that starts fast and slows down when reddit rate limits.
+func (dl *Downloader) SetAdaptiveThrottle() {
+	dl.stopThrottle()
+	dl.adaptiveThrottle = newAdaptiveThrottle()
+	dl.throttler = dl.adaptiveThrottle.C
+	dl.stopThrottle = dl.adaptiveThrottle.Stop
+}
+
+// SetWriteThrottle makes the Downloader wait at least interval between writing files, independent of the api throttle.
+// Downloads are still read into their temporary file right away and only wait before they are moved into place,
+// so the wait doesn't count against ImageTimeout. An interval <= 0 disables it.
+func (dl *Downloader) SetWriteThrottle(interval time.Duration) {
+	dl.stopWriteThrottle()
+	if interval <= 0 {
+		dl.writeThrottler = unthrottled()
+		dl.stopWriteThrottle = func() {}
+		return
+	}
+	ticker := newImmediateTicker(interval, 1)
+	dl.writeThrottler = ticker.C
+	dl.stopWriteThrottle = ticker.Stop
+}
+
+// SetMaxRuntime sets a deadline for the whole run, after which running image downloads are cancelled and
+// Done is closed. A duration <= 0 disables it.
+func (dl *Downloader) SetMaxRuntime(d time.Duration) {
+	dl.cancelRun()
+	if d <= 0 {
+		dl.runCtx = context.Background()
+		dl.cancelRun = func() {}
+		return
+	}
+	dl.runCtx, dl.cancelRun = context.WithTimeout(context.Background(), d)
+}
+
+// Done is closed when the deadline of SetMaxRuntime is reached, it is never closed without one
+func (dl *Downloader) Done() <-chan struct{} {
+	return dl.runCtx.Done()
+}
+
+// RuntimeExceeded reports whether the deadline of SetMaxRuntime was reached
+func (dl *Downloader) RuntimeExceeded() bool {
+	return dl.runCtx.Err() != nil
+}
+
+// SetOrientations allows only the given image orientations (landscape, portrait, square or all).
+func (dl *Downloader) SetOrientations(orientations []string) {
+	dl.NoLandscape = true
+	dl.NoPortrait = true
+	dl.NoSquare = true
+	for _, o := range orientations {
+		if o == "portrait" {
+			dl.NoPortrait = false
+		} else if o == "landscape" {
+			dl.NoLandscape = false
+		} else if o == "square" {
+			dl.NoSquare = false
+		} else if o == "all" {
+			dl.NoPortrait = false
+			dl.NoLandscape = false
+			dl.NoSquare = false
+		}
+	}
+}
+
+// SetTypes allows only the given image types (the names of ImageTypes), unknown names are ignored.
+func (dl *Downloader) SetTypes(types []string) {
+	for _, t := range types {
+		if tt, ok := ImageTypes[t]; ok {
+			dl.AllowTypes[tt] = struct{}{}
+		}
+	}
+}
+
+// parsesImages reports whether images have to be decoded for the filters, otherwise only their size is checked
+func (dl *Downloader) parsesImages() bool {
+	return len(dl.AllowTypes) > 0 || dl.NoLandscape || dl.NoPortrait || dl.NoSquare || dl.MinWidth > 0 || dl.MinHeight > 0 || dl.MaxWidth > 0 || dl.MaxHeight > 0 || dl.MaxAspect > 0 || (dl.Filter != nil && dl.Filter.usesImage) || len(dl.MinSizeTypes) > 0 || len(dl.MaxSizeTypes) > 0 || dl.SkipScreenshots || dl.StaticOnly || dl.RequireDecodable || dl.targetFiltersUseImage()
+}
+
+// Handle applies the submission filters to submission and fetches it if it passes, or renders its paths with CheckTemplate.
+func (dl *Downloader) Handle(submission Submission) {
+	dl.pause.wait()
+	dl.countSubmission(submission)
+	if submission.Nsfw && !dl.Nsfw {
+		dl.logSkip(submission, submission.Url, "NSFW", "skipping NSFW: %s (%s)", submission.Url, submission.Permalink)
+	} else if dl.tooOld(submission) {
+		dl.logSkip(submission, submission.Url, "older than max age", "skipping older than %s: %s (%s)", dl.MaxAge.String(), submission.Url, submission.Permalink)
+	} else if dl.targetConfig(submission.Target) == nil && submission.Score < dl.MinScore {
+		dl.logSkip(submission, submission.Url, fmt.Sprintf("score below %d", dl.MinScore), "skipping score below %d (has %d): %s (%s)", dl.MinScore, submission.Score, submission.Url, submission.Permalink)
+	} else if ok, msg := dl.matchTargetConfig(submission, dl.MinScore); !ok {
+		dl.logSkip(submission, submission.Url, msg, "skipping %s: %s (%s)", msg, submission.Url, submission.Permalink)
+	} else if dl.NoCrossposts && submission.IsCrosspost {
+		dl.logSkip(submission, submission.Url, "crosspost", "skipping crosspost: %s (%s)", submission.Url, submission.Permalink)
+	} else if dl.OnlyCrossposts && !submission.IsCrosspost {
+		dl.logSkip(submission, submission.Url, "not a crosspost", "skipping non-crosspost: %s (%s)", submission.Url, submission.Permalink)
+	} else if dl.OnlyOc && !submission.IsOriginalContent {
+		dl.logSkip(submission, submission.Url, "not OC", "skipping non-OC: %s (%s)", submission.Url, submission.Permalink)
+	} else if dl.Filter != nil && !dl.Filter.usesImage && !dl.Filter.Match(submission, 0, 0) {
+		dl.logSkip(submission, submission.Url, "filter mismatch", "skipping filter mismatch: %s (%s)", submission.Url, submission.Permalink)
+	} else if dl.DedupeTitles && dl.seenTitle(submission.Title) {
+		dl.logSkip(submission, submission.Url, "duplicate title", "skipping duplicate title: %s (%s)", submission.Url, submission.Permalink)
+	} else if dl.CheckTemplate {
+		dl.checkSubmissionPaths(dl.mapUserSubreddit(submission))
+	} else {
+		_ = dl.FetchSubmission(dl.mapUserSubreddit(submission))
+	}
+}
+
+// tooOld reports whether submission was created longer than MaxAge ago
+func (dl *Downloader) tooOld(submission Submission) bool {
+	return dl.MaxAge > 0 && time.Since(time.Unix(int64(submission.CreatedUtc), 0)) > dl.MaxAge
+}
+
+// reachedMaxAge reports whether a page of a newest first listing contains a submission older than MaxAge
+func (dl *Downloader) reachedMaxAge(children []Submission) bool {
+	for _, submission := range children {
+		if dl.tooOld(submission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finish stops the throttles and writes everything that is collected during the run:
+// archives, galleries, the dedupe report, the failures file and the probe statistics.
+func (dl *Downloader) Finish() {
+	dl.cancelRun()
+	dl.stopThrottle()
+	dl.stopWriteThrottle()
+	if dl.ArchiveFormat != "" {
+		dl.closeArchives()
+	}
+	if dl.Gallery {
+		dl.writeGalleries()
+	}
+	if dl.DedupeReport != "" {
+		if err := dl.writeDedupeReport(); err != nil {
+			dl.LogFailure("writing %s => %v", dl.DedupeReport, err)
+		}
+	}
+	if dl.FailuresFile != "" && dl.WritesFiles() {
+		if err := dl.writeFailuresFile(); err != nil {
+			dl.LogFailure("writing %s => %v", dl.FailuresFile, err)
+		}
+	}
+	if dl.Probe {
+		dl.printProbe()
+	}
+	if dl.OnlyNewHashes {
+		log.Printf("found %d new hashes", dl.newHashes)
+	}
+	if dl.hostTimings != nil {
+		dl.logHostTimings()
+	}
+}
+
+// WritesFiles reports whether the run writes files and state, which -probe, -check-template and -only-new-hashes don't
+func (dl *Downloader) WritesFiles() bool {
+	return !dl.Probe && !dl.CheckTemplate && !dl.OnlyNewHashes
+}
+
+// Failures returns the number of failed downloads and writes so far
+func (dl *Downloader) Failures() int {
+	return dl.failures
+}
+
+// Pause stops Handle and the Listers before their next request until it is called with false.
+// Requests that are already running finish.
+func (dl *Downloader) Pause(paused bool) {
+	dl.pause.set(paused)
+}
+
+// ImageTypes maps the names accepted by -type to the format names of the image package
+var ImageTypes = map[string]string{
+	"png":  "png",
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"gif":  "gif",
+	"webp": "webp",
+	"tif":  "tiff",
+	"tiff": "tiff",
+	"bmp":  "bmp",
+	"avif": "avif",
+	"heic": "heic",
+	"heif": "heic",
+}
+
+// NormalizeOutputRoot expands a leading ~ to the home directory and cleans the path
+func NormalizeOutputRoot(root string) (string, error) {
+	if root == "~" || strings.HasPrefix(root, "~/") || strings.HasPrefix(root, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		root = filepath.Join(home, root[1:])
+	}
+	return filepath.Clean(root), nil
+}
+
+// CheckOutputRoot creates the output directory and makes sure files can be written to it
+func CheckOutputRoot(root string) error {
+	err := os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(root, ".write-test-")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+func ParseSize(size string) (int, error) {
+	size = strings.TrimSpace(strings.ToLower(size))
+	if size == "" {
+		return 0, nil
+	}
+	var numStr string
+	var suffix string
+	// split into num and suffix on first non-digit rune
+	for i, ch := range size {
+		if !unicode.IsDigit(ch) {
+			numStr = strings.TrimSpace(size[:i])
+			suffix = strings.TrimSpace(size[i:])
+			break
+		}
+	}
+	num, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	var factor float64
+	if suffix == "" || suffix == "b" {
+		factor = 1
+	} else if suffix == "k" || suffix == "kb" {
+		factor = 1024
+	} else if suffix == "m" || suffix == "mb" {
+		factor = 1024 * 1024
+	} else if suffix == "g" || suffix == "gb" {
+		factor = 1024 * 1024 * 1024
+	} else {
+		return 0, fmt.Errorf("invalid size suffix: %s", suffix)
+	}
+	return int(num * factor), nil
+}
+
+// ParseSizeTypes parses a comma separated list of type=size pairs
+func ParseSizeTypes(list string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	if strings.TrimSpace(list) == "" {
+		return sizes, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected type=size, got %s", pair)
+		}
+		t, ok := ImageTypes[strings.TrimSpace(strings.ToLower(parts[0]))]
+		if !ok {
+			return nil, fmt.Errorf("unknown type: %s", parts[0])
+		}
+		size, err := ParseSize(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		sizes[t] = size
+	}
+	return sizes, nil
+}
+
+func (dl *Downloader) FetchSubmission(submission Submission) error {
+	if submission.IsSelf && dl.SaveText {
+		return dl.fetchSelfPost(submission)
+	}
+	if isRedditPostUrl(submission.Url) {
+		parent, err := dl.resolveCrosspost(submission)
+		if err != nil {
+			dl.logFetchFailure(submission, submission.Url, err.Error(), "resolving crosspost %s (%s) => %v", submission.Url, submission.Permalink, err)
+			return err
+		}
+		// keep the crosspost's own data for the path templates, but fetch the parent's media
+		submission.Url = parent.Url
+		submission.Domain = parent.Domain
+		submission.PostHint = parent.PostHint
+		submission.Media = parent.Media
+		submission.SecureMedia = parent.SecureMedia
+		submission.Preview = parent.Preview
+		submission.IsGallery = parent.IsGallery
+		submission.GalleryData = parent.GalleryData
+		submission.MediaMetadata = parent.MediaMetadata
+	}
+	if dl.PreferSourceComment && submission.PostHint == "image" && !submission.IsGallery && submission.GalleryData == nil {
+		if source := dl.sourceUrl(submission); source != "" {
+			err := dl.fetchSource(submission, source)
+			if err != errImageNotFound {
+				return err
+			}
+			log.Printf("fetching %s (%s) => not found, falling back to %s", source, submission.Permalink, submission.Url)
+		}
+	}
+	if submission.IsGallery || submission.GalleryData != nil {
+		// checked before the post hint, which is "image" for galleries of some clients
+		return dl.fetchRedditGallery(submission)
+	} else if submission.PostHint == "image" {
+		if dl.Unblur && submission.Spoiler {
+			if u := largestPreview(submission); u != "" {
+				return dl.FetchSingleImage(u, submission)
+			}
+		}
+		candidates := []string{submission.Url}
+		if preview := largestPreview(submission); preview != "" {
+			candidates = append(candidates, preview)
+		}
+		err := dl.fetchFirst(submission, candidates, true)
+		if err == errImageNotFound {
+			dl.logFetchFailure(submission, submission.Url, "not found", "fetching %s (%s) => not found\n", submission.Url, submission.Permalink)
+		}
+		return err
+	} else if submission.Domain == "imgur.com" {
+		return dl.FetchImgur(submission)
+	} else if thumbnail := embedThumbnail(submission); dl.Embeds && thumbnail != "" {
+		return dl.FetchSingleImage(thumbnail, submission)
+	} else {
+		return fmt.Errorf("could not fetch %s, unknown service %s", submission.Url, submission.Domain)
+	}
+}
+
+// embedThumbnail returns the thumbnail of embedded media (youtube, streamable, ...) or an empty string.
+func embedThumbnail(submission Submission) string {
+	if submission.SecureMedia != nil && submission.SecureMedia.Oembed.ThumbnailUrl != "" {
+		return submission.SecureMedia.Oembed.ThumbnailUrl
+	}
+	if submission.Media != nil {
+		return submission.Media.Oembed.ThumbnailUrl
+	}
+	return ""
+}
+
+// isRedditPostUrl reports whether u links to a reddit submission instead of media, as some crossposts do.
+func isRedditPostUrl(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	if parsed.Host != "" && parsed.Host != "reddit.com" && !strings.HasSuffix(parsed.Host, ".reddit.com") {
+		return false
+	}
+	return strings.Contains(parsed.Path, "/comments/")
+}
+
+// resolveCrosspost returns the submission a crosspost points to,
+// either from crosspost_parent_list or by fetching the parent's json.
+func (dl *Downloader) resolveCrosspost(submission Submission) (SubmissionData, error) {
+	var parent SubmissionData
+	if len(submission.CrosspostParentList) > 0 {
+		parent = submission.CrosspostParentList[0]
+	} else {
+		u, err := url.Parse(submission.Url)
+		if err != nil {
+			return SubmissionData{}, err
+		}
+		<-dl.throttler
+		post, err := dl.redditClient.GetPost(u.Path)
+		if err != nil {
+			return SubmissionData{}, err
+		}
+		parent = post.SubmissionData
+	}
+	if isRedditPostUrl(parent.Url) {
+		return SubmissionData{}, fmt.Errorf("parent %s links to another reddit post", parent.Permalink)
+	}
+	return parent, nil
+}
+
+// getImage starts the download of an image, which is bounded by -image-timeout including reading the body.
+// cancel must be called after the body was read.
+func (dl *Downloader) getImage(u string) (*http.Response, context.CancelFunc, error) {
+	return dl.getImageRange(u, 0)
+}
+
+// getImageRange is getImage for only the first n bytes of an image, or all of it if n is 0.
+// Servers may ignore the range and send all of it with status 200.
+func (dl *Downloader) getImageRange(u string, n int) (*http.Response, context.CancelFunc, error) {
+	ctx := dl.runCtx
+	cancel := context.CancelFunc(func() {})
+	if dl.ImageTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, dl.ImageTimeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if n > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+	}
+	resp, err := dl.ImageClient.Do(req)
+	if err != nil {
+		cancel()
+		if dl.runCtx.Err() != nil {
+			err = errors.New("stopped by -max-runtime")
+		} else if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("abandoned after %s", dl.ImageTimeout.String())
+		}
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+var errImageNotFound = errors.New("image not found")
+
+// imgurRemoved reports whether resp is imgur's placeholder for removed images, which it redirects to with status 200
+func imgurRemoved(resp *http.Response) bool {
+	return resp.Request.URL.Host == "i.imgur.com" && strings.HasSuffix(resp.Request.URL.Path, "removed.png")
+}
+
+// imgur serves images under any of these extensions, the right one isn't known for links to imgur.com/<hash>
+var imgurExtensions = []string{".png", ".jpg", ".gif", ".webp"}
+
+// fetchPreview downloads the largest preview of a submission whose image is gone.
+// It returns errImageNotFound if there is no usable preview either.
+func (dl *Downloader) fetchPreview(submission Submission) error {
+	u := largestPreview(submission)
+	if u == "" {
+		return errImageNotFound
+	}
+	log.Printf("fetching %s (%s) => not found, falling back to the preview", submission.Url, submission.Permalink)
+	return dl.tryFetchSingleImage(u, submission)
+}
+
+// largestPreview returns the url of the largest preview resolution, or "" if there is none.
+// The variants are ignored, obscured previews of nsfw and spoiler submissions are only there.
+func largestPreview(submission Submission) string {
+	if submission.Preview == nil {
+		return ""
+	}
+	best := PreviewSource{}
+	for _, img := range submission.Preview.Images {
+		for _, res := range append([]PreviewSource{img.Source}, img.Resolutions...) {
+			if res.Url == "" || strings.Contains(res.Url, "blur=") {
+				continue
+			}
+			if res.Width*res.Height > best.Width*best.Height || best.Url == "" {
+				best = res
+			}
+		}
+	}
+	return best.Url
+}
+
+func (dl *Downloader) FetchSingleImage(u string, submission Submission) error {
+	err := dl.tryFetchSingleImage(u, submission)
+	if err == errImageNotFound {
+		dl.logFetchFailure(submission, u, "not found", "fetching %s (%s) => not found\n", u, submission.Permalink)
+	}
+	return err
+}
+
+// imageFetch is one image for fetchChecked
+type imageFetch struct {
+	u          string
+	submission Submission
+	// dedupe enables the duplicate detection by url, fingerprint and hash (-skip-duplicates or -skip-duplicates-in-albums)
+	dedupe bool
+	// linkPath renders the path a duplicate is hard linked to with the extension of the existing file,
+	// nil if duplicates are only skipped
+	linkPath func(ext string) string
+	// video checks the download with checkVideo, videos served with a video/ content type always are
+	video bool
+	// missingOk returns errImageNotFound for missing images without logging them, so callers can try other urls first
+	missingOk bool
+}
+
+// checkedImage is a download that passed the duplicate detection and the filters of fetchChecked
+type checkedImage struct {
+	*download
+	// fp is the fingerprint for rememberFingerprint, "" if there is none
+	fp          string
+	contentType string
+}
+
+// fetchChecked downloads an image and runs it through the duplicate detection and the filters, which the single image,
+// album and contact sheet paths share. Skips and failures are logged: it returns nil and no error for skips and the
+// error for failures, errImageNotFound for missing images. The caller has to discard the download.
+func (dl *Downloader) fetchChecked(f imageFetch) (*checkedImage, error) {
+	u, submission := f.u, f.submission
+	linkDuplicate := func(existing string) bool {
+		if f.linkPath == nil || !dl.HardlinkDuplicates || existing == "" {
+			return false
+		}
+		dl.linkDuplicate(existing, f.linkPath(filepath.Ext(existing)), u, submission)
+		return true
+	}
+	if f.dedupe {
+		existing, exists := dl.knownUrls[u]
+		if exists {
+			if !linkDuplicate(existing) {
+				dl.logSkip(submission, u, "duplicate", "skipping %s (%s)\n", u, submission.Permalink)
+			}
+			return nil, nil
+		}
+		dl.knownUrls[u] = ""
+	}
+
+	if dl.ExcludeAlreadyLinked && dl.seenImgurHash(u) {
+		dl.logSkip(submission, u, "imgur image already downloaded", "skipping %s (%s), imgur image already downloaded\n", u, submission.Permalink)
+		return nil, nil
+	}
+
+	var fp string
+	if f.dedupe {
+		var duplicate bool
+		fp, duplicate = dl.fastDuplicate(u, submission, f.linkPath)
+		if duplicate {
+			return nil, nil
+		}
+	}
+
+	resp, cancel, err := dl.getImage(u)
+	if err != nil {
+		dl.logFetchFailure(submission, u, err.Error(), "fetching %s (%s) => %v", u, submission.Permalink, err)
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("error closing response body: %v", err)
+		}
+		cancel()
+	}()
+
+	if resp.StatusCode == 404 || imgurRemoved(resp) {
+		if dl.ExcludeAlreadyLinked {
+			// the image might still exist under another extension
+			delete(dl.knownImgurHashes, originalName(u))
+		}
+		if !f.missingOk {
+			dl.logFetchFailure(submission, u, "not found", "fetching %s (%s) => not found\n", u, submission.Permalink)
+		}
+		return nil, errImageNotFound
+	} else if resp.StatusCode >= 300 {
+		dl.logFetchFailure(submission, u, fmt.Sprintf("HTTP status %d", resp.StatusCode), "fetching %s (%s) => HTTP status %d\n", u, submission.Permalink, resp.StatusCode)
+		return nil, fmt.Errorf("status code is not 2XX")
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if ok, msg := dl.checkContentType(contentType); !ok {
+		// don't read the rest of the body
+		cancel()
+		dl.logSkip(submission, u, msg, "fetching %s (%s) => %s, skipping", u, submission.Permalink, msg)
+		return nil, nil
+	}
+
+	limit := dl.downloadLimit()
+	if limit > 0 && resp.ContentLength > int64(limit) {
+		cancel()
+		dl.logSkip(submission, u, fmt.Sprintf("greater than %d bytes", limit), "fetching %s (%s) => greater than %d bytes, skipping", u, submission.Permalink, limit)
+		return nil, nil
+	}
+	body := newLimitReader(resp.Body, limit)
+
+	d, err := dl.streamDownload(body)
+	if dl.skipTooLarge(err, u, submission, limit) {
+		cancel()
+		return nil, nil
+	}
+	if err != nil {
+		dl.logFetchFailure(submission, u, err.Error(), "fetching %s (%s) => %v", u, submission.Permalink, err)
+		return nil, err
+	}
+
+	if f.dedupe {
+		dl.recordDedupeSource(d.hash, u, submission)
+		existing, exists := dl.knownHashes[d.hash]
+		if exists {
+			d.discard()
+			dl.refreshHash(d.hash)
+			if !linkDuplicate(existing) {
+				dl.logSkip(submission, u, "duplicate", "fetching %s (%s) => hash exists already, skipping", u, submission.Permalink)
+			}
+			return nil, nil
+		}
+		dl.knownHashes[d.hash] = ""
+	}
+
+	var ok bool
+	var msg string
+	if f.video || strings.HasPrefix(contentType, "video/") {
+		ok, msg = dl.checkVideo(d.size, submission)
+	} else {
+		ok, msg = dl.checkImage(d, submission)
+	}
+	if !ok {
+		d.discard()
+		dl.logSkip(submission, u, msg, "fetching %s (%s) => %s, skipping", u, submission.Permalink, msg)
+		return nil, nil
+	}
+	return &checkedImage{download: d, fp: fp, contentType: contentType}, nil
+}
+
+// tryFetchSingleImage is FetchSingleImage without logging errImageNotFound, so callers can try other urls first
+func (dl *Downloader) tryFetchSingleImage(u string, submission Submission) error {
+	c, err := dl.fetchChecked(imageFetch{
+		u:          u,
+		submission: submission,
+		dedupe:     dl.SkipDuplicates,
+		linkPath: func(ext string) string {
+			return dl.renderSinglePath(submission, u, ext, time.Time{})
+		},
+		missingOk: true,
+	})
+	if c == nil {
+		return err
+	}
+	d, fp := c.download, c.fp
+	defer d.discard()
+
+	if dl.Probe {
+		dl.recordProbe(d.header)
+		return nil
+	}
+	if dl.OnlyNewHashes {
+		dl.recordNewHash(submission, u, d)
+		dl.rememberFingerprint(fp, "")
+		return nil
+	}
+
+	// read before -auto-orient, which drops the EXIF data
+	taken := jpegDateTimeOriginal(d.header)
+	if dl.AutoOrient {
+		if _, err := autoOrientDownload(d); err != nil {
+			log.Printf("orienting %s (%s) => %v, keeping it as is", u, submission.Permalink, err)
+		}
+	}
+	converted := dl.GifToMp4 && dl.gifToMp4(d, u, submission)
+
+	parsedUrl, _ := url.Parse(u)
+	ext := path.Ext(parsedUrl.Path)
+
+	contentType := c.contentType
+	if contentType != "" {
+		exts, err := mime.ExtensionsByType(contentType)
+		if err == nil && len(exts) > 0 {
+			if ext == "" {
+				ext = exts[0]
+			} else {
+				valid := false
+				for _, e := range exts {
+					if e == ext {
+						valid = true
+						break
+					}
+				}
+				if !valid {
+					ext = exts[0]
+				}
+			}
+		}
+	}
+	if converted {
+		ext = ".mp4"
+	}
+
+	p := dl.renderSinglePath(submission, u, ext, taken)
+
+	if !dl.Overwrite {
+		if dl.outputExists(submission, p) {
+			dl.logSkip(submission, u, "file exists", "fetching %s (%s) => file exists, overwrite disabled", u, submission.Permalink)
+			return nil
+		}
+	}
+
+	err = dl.saveDownload(submission, d, p)
+	if err != nil {
+		dl.logFetchFailure(submission, u, err.Error(), "fetching %s (%s) => %v", u, submission.Permalink, err)
+		return err
+	}
+	if dl.SkipDuplicates {
+		dl.knownUrls[u] = p
+		dl.knownHashes[d.hash] = p
+	}
+	dl.setModTime(p, postTime(submission))
+	dl.countDownload(submission, d.size)
+	dl.rememberHash(d.hash)
+	dl.rememberFingerprint(fp, p)
+	dl.writeRawMetadata(submission, p)
+	dl.writeChecksum(submission, p, d.sum)
+	dl.mirrorFile(p, u, submission)
+	dl.addGalleryEntry(submission, p)
+	dl.logSave(saveEvent(submission, u, p, d), "fetching %s (%s) => %s", u, submission.Permalink, p)
+	return nil
+}
+
+func (dl *Downloader) FetchImgur(submission Submission) error {
+	u, err := url.Parse(submission.Url)
+	if err != nil {
+		dl.logFetchFailure(submission, submission.Url, "invalid url", "invalid url: %s", submission.Url)
+		return err
+	}
+	if id := imgurGalleryId(u.Path); id != "" {
+		item, err := dl.imgurClient.GetGalleryItem(id)
+		if err != nil {
+			dl.logFetchFailure(submission, submission.Url, err.Error(), "fetching imgur gallery: %s (%s) => %v", submission.Url, submission.Permalink, err)
+			return err
+		}
+		// continue as if the submission linked to the album or image directly
+		if item.Image.IsAlbum {
+			u.Path = "/a/" + item.Image.Hash
+		} else {
+			u.Path = "/" + item.Image.Hash
+		}
+	}
+	if strings.HasPrefix(u.Path, "/a/") {
+		if dl.NoAlbums {
+			dl.logSkip(submission, submission.Url, "albums disabled", "skipping imgur album: %s\n", submission.Url)
+			return nil
+		}
+		albumId := strings.TrimPrefix(u.Path, `/a/`)
+		if dl.SkipDuplicates {
+			_, exists := dl.knownUrls[submission.Url]
+			if exists {
+				dl.logSkip(submission, submission.Url, "duplicate", "skipping imgur album: %s\n", submission.Url)
+				return nil
+			}
+			dl.knownUrls[submission.Url] = ""
+		}
+		album, err := dl.imgurClient.GetAlbum(albumId)
+		if err != nil {
+			dl.logFetchFailure(submission, submission.Url, err.Error(), "fetching imgur album: %s (%s) => %v", submission.Url, submission.Permalink, err)
+			return err
+		}
+
+		count := len(album.Images)
+		if album.Count > count {
+			log.Printf("fetching imgur album: %s (%s) => imgur returned %d of %d images", submission.Url, submission.Permalink, count, album.Count)
+			count = album.Count
+		}
+		return dl.fetchAlbum(submission, album.Images, count)
+	} else {
+		candidates := dl.imgurImageUrls(u.Path, submission)
+		if candidates == nil {
+			// try the common extensions before giving up, images are sometimes only available under their original one,
+			// and videos only as mp4
+			for _, ext := range append(append([]string(nil), imgurExtensions...), ".mp4") {
+				candidates = append(candidates, `https://i.imgur.com`+u.Path+ext)
+			}
+		}
+		err = dl.fetchFirst(submission, candidates, false)
+		if err != errImageNotFound {
+			return err
+		}
+		err = dl.fetchPreview(submission)
+		if err == errImageNotFound {
+			dl.logFetchFailure(submission, submission.Url, "not found", "fetching %s (%s) => not found\n", submission.Url, submission.Permalink)
+		}
+		return err
+	}
+}
+
+// imgurImageUrls looks up the url of a single imgur image (imgur.com/<hash>) with the official api, the mp4 first for
+// videos and with -prefer-mp4 for animated images. It returns nil without -imgur-client-id or if the lookup fails.
+func (dl *Downloader) imgurImageUrls(p string, submission Submission) []string {
+	hash := strings.Trim(p, "/")
+	if dl.imgurClient.clientId == "" || hash == "" || strings.Contains(hash, "/") {
+		return nil
+	}
+	image, err := dl.imgurClient.GetImage(hash)
+	if err != nil {
+		log.Printf("looking up imgur image %s (%s) => %v, guessing the extension", submission.Url, submission.Permalink, err)
+		return nil
+	}
+	if image.Animated && image.Mp4 != "" && (dl.PreferMp4 || strings.HasPrefix(image.Type, "video/")) && image.Mp4 != image.Link {
+		return []string{image.Mp4, image.Link}
+	}
+	return []string{image.Link}
+}
+
+// SetImgurClientId sets the client id of a registered imgur application, which enables looking up single images
+// with the official api
+func (dl *Downloader) SetImgurClientId(id string) {
+	dl.imgurClient.clientId = id
+}
+
+// fetchAlbum downloads the images of an imgur album or reddit gallery, count is the number of images in the album,
+// which can be more than the images that are available
+func (dl *Downloader) fetchAlbum(submission Submission, images []AlbumImage, count int) error {
+	if count < dl.MinAlbumImages {
+		dl.logSkip(submission, submission.Url, fmt.Sprintf("less than %d images", dl.MinAlbumImages), "skipping album with less than %d images (has %d): %s (%s)", dl.MinAlbumImages, count, submission.Url, submission.Permalink)
+		return nil
+	}
+	if dl.MaxAlbumImages > 0 && count > dl.MaxAlbumImages {
+		dl.logSkip(submission, submission.Url, fmt.Sprintf("more than %d images", dl.MaxAlbumImages), "skipping album with more than %d images (has %d): %s (%s)", dl.MaxAlbumImages, count, submission.Url, submission.Permalink)
+		return nil
+	}
+
+	if dl.SingleImageAlbumsAsSingles && len(images) == 1 {
+		return dl.FetchSingleImage(albumImageUrl(images[0]), submission)
+	}
+	if dl.ContactSheetColumns > 0 && dl.WritesFiles() {
+		return dl.fetchContactSheet(submission, images)
+	}
+
+	downloaded := 0
+	for i, img := range images {
+		if dl.AlbumLimit > 0 && downloaded >= dl.AlbumLimit {
+			dl.logSkip(submission, submission.Url, fmt.Sprintf("album limit of %d images reached", dl.AlbumLimit), "album limit of %d images reached: %s (%s)", dl.AlbumLimit, submission.Url, submission.Permalink)
+			break
+		}
+		if dl.fetchAlbumImage(submission, img, i+1, count) {
+			downloaded++
+		}
+	}
+	return nil
+}
+
+// albumImageUrl is the url of an album image, imgur images don't have one in the album data
+func albumImageUrl(img AlbumImage) string {
+	if img.Url != "" {
+		return img.Url
+	}
+	return fmt.Sprintf(`https://i.imgur.com/%s%s`, img.Hash, img.Ext)
+}
+
+// albumImageMp4 returns the url of the mp4 version of an animated album image, or "" if there is none
+func albumImageMp4(img AlbumImage) string {
+	if !img.Animated {
+		return ""
+	}
+	if img.Mp4 != "" {
+		return img.Mp4
+	}
+	if img.Url == "" {
+		return fmt.Sprintf(`https://i.imgur.com/%s.mp4`, img.Hash)
+	}
+	return ""
+}
+
+// fetchAlbumImage downloads the num-th image of an album with count images and reports whether it was written
+func (dl *Downloader) fetchAlbumImage(submission Submission, img AlbumImage, num int, count int) bool {
+	ext := img.Ext
+	u := albumImageUrl(img)
+	if mp4 := albumImageMp4(img); mp4 != "" && (dl.PreferMp4 || dl.preferredOrder([]string{u, mp4})[0] == mp4) {
+		ext = ".mp4"
+		u = mp4
+	}
+	c, _ := dl.fetchChecked(imageFetch{
+		u:          u,
+		submission: submission,
+		dedupe:     dl.SkipDuplicatesInAlbums,
+		linkPath: func(ext string) string {
+			return dl.renderAlbumPath(submission, img, num, count, ext, time.Time{})
+		},
+		video: ext == ".mp4",
+	})
+	if c == nil {
+		return false
+	}
+	d, fp := c.download, c.fp
+	defer d.discard()
+
+	if dl.Probe {
+		dl.recordProbe(d.header)
+		return false
+	}
+	if dl.OnlyNewHashes {
+		dl.recordNewHash(submission, u, d)
+		dl.rememberFingerprint(fp, "")
+		return false
+	}
+
+	// read before -auto-orient, which drops the EXIF data
+	taken := jpegDateTimeOriginal(d.header)
+	if dl.AutoOrient && ext != ".mp4" {
+		if _, err := autoOrientDownload(d); err != nil {
+			log.Printf("orienting %s (%s) => %v, keeping it as is", u, submission.Permalink, err)
+		}
+	}
+	if dl.GifToMp4 && ext != ".mp4" && dl.gifToMp4(d, u, submission) {
+		ext = ".mp4"
+	}
+
+	p := dl.renderAlbumPath(submission, img, num, count, ext, taken)
+
+	if !dl.Overwrite {
+		if dl.outputExists(submission, p) {
+			dl.logSkip(submission, u, "file exists", "fetching %s (%s) => file exists, overwrite disabled", u, submission.Permalink)
+			return false
+		}
+	}
+
+	if err := dl.saveDownload(submission, d, p); err != nil {
+		dl.logFetchFailure(submission, u, err.Error(), "fetching %s (%s) => %v", u, submission.Permalink, err)
+		return false
+	}
+	if dl.SkipDuplicatesInAlbums {
+		dl.knownUrls[u] = p
+		dl.knownHashes[d.hash] = p
+	}
+	dl.setModTime(p, albumImageTime(img, submission))
+	dl.countDownload(submission, d.size)
+	dl.rememberHash(d.hash)
+	dl.rememberFingerprint(fp, p)
+	dl.writeRawMetadata(submission, p)
+	dl.writeChecksum(submission, p, d.sum)
+	dl.mirrorFile(p, u, submission)
+	dl.addGalleryEntry(submission, p)
+	dl.logSave(saveEvent(submission, u, p, d), "fetching %s (%s) => %s\n", u, submission.Permalink, p)
+	return true
+}
+
+// imgurGalleryId returns the id of /gallery/<id> and /t/<topic>/<id> paths, or "" for other paths.
+// Newer gallery urls prefix the id with the slugified title, e.g. /gallery/funny-cat-AbC123.
+func imgurGalleryId(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	var id string
+	if len(parts) == 2 && parts[0] == "gallery" {
+		id = parts[1]
+	} else if len(parts) == 3 && parts[0] == "t" {
+		id = parts[2]
+	} else {
+		return ""
+	}
+	if i := strings.LastIndex(id, "-"); i >= 0 {
+		id = id[i+1:]
+	}
+	return id
+}
+
+// seenImgurHash reports whether the imgur image behind u was seen before, under any extension or in an album,
+// and remembers it otherwise. Urls of other hosts are never seen.
+func (dl *Downloader) seenImgurHash(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host != "i.imgur.com" {
+		return false
+	}
+	hash := originalName(u)
+	if _, exists := dl.knownImgurHashes[hash]; exists {
+		return true
+	}
+	dl.knownImgurHashes[hash] = struct{}{}
+	return false
+}
+
+// forgetUnwritten drops the known urls and hashes that weren't written, e.g. because their download failed
+func (dl *Downloader) forgetUnwritten() {
+	for u, p := range dl.knownUrls {
+		if p == "" {
+			delete(dl.knownUrls, u)
+		}
+	}
+	for hash, p := range dl.knownHashes {
+		if p == "" {
+			delete(dl.knownHashes, hash)
+		}
+	}
+}
+
+// linkDuplicate creates p as a hardlink to the already downloaded duplicate existing, or as a symlink if hardlinks fail
+func (dl *Downloader) linkDuplicate(existing string, p string, u string, submission Submission) {
+	if p == existing {
+		dl.logSkip(submission, u, "duplicate", "fetching %s (%s) => duplicate of %s, skipping", u, submission.Permalink, existing)
+		return
+	}
+	if _, err := os.Lstat(p); err == nil || !os.IsNotExist(err) {
+		if !dl.Overwrite {
+			dl.logSkip(submission, u, "file exists", "fetching %s (%s) => file exists, overwrite disabled", u, submission.Permalink)
+			return
+		}
+		_ = os.Remove(p)
+	}
+
+	_ = os.MkdirAll(filepath.Dir(p), os.ModeDir)
+	err := os.Link(existing, p)
+	if err != nil {
+		target, absErr := filepath.Abs(existing)
+		if absErr != nil {
+			dl.LogFailure("linking %s (%s) => %v", u, submission.Permalink, absErr)
+			return
+		}
+		err = os.Symlink(target, p)
+	}
+	if err != nil {
+		dl.LogFailure("linking %s (%s) => %v", u, submission.Permalink, err)
+		return
+	}
+	dl.mirrorFile(p, u, submission)
+	dl.addGalleryEntry(submission, p)
+	dl.logSave(DownloadEvent{Submission: submission, Url: u, Path: p}, "linking %s (%s) => %s", u, submission.Permalink, p)
+}
+
+// writeRawMetadata writes the submission json as returned by reddit next to the image, if -metadata-raw is set
+func (dl *Downloader) writeRawMetadata(submission Submission, p string) {
+	if !dl.MetadataRaw || len(submission.RawData) == 0 {
+		return
+	}
+	err := dl.writeOutput(submission, p+".json", submission.RawData)
+	if err != nil {
+		log.Printf("writing metadata %s.json => %v", p, err)
+	}
+}
+
+// LogFailure logs failed downloads and counts them for the exit code
+func (dl *Downloader) LogFailure(format string, v ...interface{}) {
+	dl.failures++
+	dl.countFailure()
+	log.Printf(format, v...)
+}
+
+// renderSinglePath renders -single-template for an image downloaded from u, relative paths are placed in the output root.
+// taken is the EXIF capture time of the image, zero if it has none or it isn't known.
+func (dl *Downloader) renderSinglePath(submission Submission, u string, ext string, taken time.Time) string {
+	created := time.Unix(int64(submission.CreatedUtc), 0)
+	if taken.IsZero() {
+		taken = created
+	}
+
+	templateData := struct {
+		Ext          string
+		OriginalName string
+		Submission   Submission
+		Time         time.Time
+		Timestamp    string
+		Now          time.Time
+		ExifTime     time.Time
+	}{
+		Ext:          ext,
+		OriginalName: originalName(u),
+		Submission:   submission,
+		Time:         created,
+		Timestamp:    created.Format("2006-01-02-15-04-05"),
+		Now:          time.Now(),
+		ExifTime:     taken,
+	}
+
+	tmpl := dl.SingleTemplate
+	if c := dl.targetConfig(submission.Target); c != nil && c.singleTemplate != nil {
+		tmpl = c.singleTemplate
+	}
+	var name bytes.Buffer
+	err := tmpl.Execute(&name, templateData)
+	if err != nil {
+		panic(fmt.Errorf("template error: %v", err))
+	}
+
+	p := name.String()
+	if dl.SanitizePaths {
+		p = sanitizePath(p)
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dl.OutputRoot, p)
+	}
+	return p
+}
+
+// renderAlbumPath renders -album-template for the num-th image of an album (starting at 1) with count images,
+// taken is like in renderSinglePath
+func (dl *Downloader) renderAlbumPath(submission Submission, img AlbumImage, num int, count int, ext string, taken time.Time) string {
+	created := time.Unix(int64(submission.CreatedUtc), 0)
+	if taken.IsZero() {
+		taken = created
+	}
+
+	templateData := struct {
+		Ext          string
+		OriginalName string
+		Submission   Submission
+		Image        AlbumImage
+		Time         time.Time
+		Timestamp    string
+		Now          time.Time
+		Num          int
+		NumPadded    string
+		ExifTime     time.Time
+	}{
+		Ext:          ext,
+		OriginalName: img.Hash,
+		Submission:   submission,
+		Image:        img,
+		Time:         created,
+		Timestamp:    created.Format("2006-01-02-15-04-05"),
+		Now:          time.Now(),
+		Num:          num,
+		NumPadded:    fmt.Sprintf("%0*d", len(strconv.Itoa(count)), num),
+		ExifTime:     taken,
+	}
+
+	tmpl := dl.AlbumTemplate
+	if c := dl.targetConfig(submission.Target); c != nil && c.albumTemplate != nil {
+		tmpl = c.albumTemplate
+	}
+	var name bytes.Buffer
+	err := tmpl.Execute(&name, templateData)
+	if err != nil {
+		panic(fmt.Errorf("template error: %v", err))
+	}
+
+	p := name.String()
+	if dl.SanitizePaths {
+		p = sanitizePath(p)
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dl.OutputRoot, p)
+	}
+	return p
+}
+
+// originalName is the last path segment of u without extension, e.g. abc123 for https://i.imgur.com/abc123.jpg
+func originalName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(parsed.Path)
+	if base == "/" || base == "." {
+		return ""
+	}
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+func slugify(str string) string {
+	return slug.Make(str)
+}
+
+// checkContentType checks the declared Content-Type of a response against -content-type.
+// Responses without the header are let through, the decoded type is still checked by -type.
+func (dl *Downloader) checkContentType(header string) (bool, string) {
+	if len(dl.AllowContentTypes) == 0 || header == "" {
+		return true, ""
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false, fmt.Sprintf("invalid content type %q", header)
+	}
+	for _, allowed := range dl.AllowContentTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("content type %s not allowed", mediaType)
+}
+
+var errTooLarge = errors.New("too large")
+
+// downloadLimit is the size above which no image can pass checkSize, whatever its type (0 = no limit)
+func (dl *Downloader) downloadLimit() int {
+	limit := dl.MaxSize
+	for _, max := range dl.MaxSizeTypes {
+		if max == 0 || limit == 0 {
+			return 0
+		}
+		if max > limit {
+			limit = max
+		}
+	}
+	return limit
+}
+
+// limitReader fails with errTooLarge once more than limit bytes were read, so oversized downloads without
+// a Content-Length are aborted early
+type limitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func newLimitReader(r io.Reader, limit int) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitReader{r: r, limit: int64(limit)}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// skipTooLarge logs a skip and returns true if err is from a download that exceeded limit
+func (dl *Downloader) skipTooLarge(err error, u string, submission Submission, limit int) bool {
+	if err != errTooLarge {
+		return false
+	}
+	dl.logSkip(submission, u, fmt.Sprintf("greater than %d bytes", limit), "fetching %s (%s) => greater than %d bytes, skipping", u, submission.Permalink, limit)
+	return true
+}
+
+// checkSize checks size against -min-size/-max-size or the per-type limits if imgType has one
+func (dl *Downloader) checkSize(size int, imgType string) (bool, string) {
+	min := dl.MinSize
+	if limit, ok := dl.MinSizeTypes[imgType]; ok {
+		min = limit
+	}
+	max := dl.MaxSize
+	if limit, ok := dl.MaxSizeTypes[imgType]; ok {
+		max = limit
+	}
+	if size < min {
+		return false, fmt.Sprintf("smaller than %d bytes", min)
+	}
+	if max > 0 && size > max {
+		return false, fmt.Sprintf("greater than %d bytes", max)
+	}
+	return true, ""
+}
+
+// orientation returns portrait, landscape or square, images whose long side is at most SquareTolerance longer than the short side are square
+func (dl *Downloader) orientation(width int, height int) string {
+	long, short := width, height
+	if height > width {
+		long, short = height, width
+	}
+	if long == short || (short > 0 && float64(long)/float64(short)-1 <= dl.SquareTolerance) {
+		return "square"
+	}
+	if height > width {
+		return "portrait"
+	}
+	return "landscape"
+}
+
+// checkImage checks an image of the given size against the filters, header has to contain at least its headers
+func (dl *Downloader) checkImage(d *download, submission Submission) (bool, string) {
+	if !dl.parsesImages() {
+		return dl.checkSize(d.size, "")
+	}
+	cfg, imgType, err := image.DecodeConfig(bytes.NewReader(d.header))
+	if err != nil {
+		return false, "failed to parse image"
+	}
+	if ok, msg := dl.checkSize(d.size, imgType); !ok {
+		return false, msg
+	}
+	if _, ok := dl.AllowTypes[imgType]; !ok && len(dl.AllowTypes) > 0 {
+		return false, fmt.Sprintf("type %s not allowed", imgType)
+	}
+	switch dl.orientation(cfg.Width, cfg.Height) {
+	case "portrait":
+		if dl.NoPortrait {
+			return false, "portrait orientation"
+		}
+	case "landscape":
+		if dl.NoLandscape {
+			return false, "landscape orientation"
+		}
+	case "square":
+		if dl.NoSquare {
+			return false, "square orientation"
+		}
+	}
+	if cfg.Width < dl.MinWidth {
+		return false, fmt.Sprintf("width < %d", dl.MinWidth)
+	}
+	if cfg.Height < dl.MinHeight {
+		return false, fmt.Sprintf("height < %d", dl.MinHeight)
+	}
+	if dl.MaxWidth > 0 && cfg.Width > dl.MaxWidth {
+		return false, fmt.Sprintf("width > %d", dl.MaxWidth)
+	}
+	if dl.MaxHeight > 0 && cfg.Height > dl.MaxHeight {
+		return false, fmt.Sprintf("height > %d", dl.MaxHeight)
+	}
+	if dl.MaxAspect > 0.0 && float64(cfg.Height)/float64(cfg.Width) > dl.MaxAspect {
+		return false, fmt.Sprintf("aspect ratio %.2f > %.2f", float64(cfg.Height)/float64(cfg.Width), dl.MaxAspect)
+	}
+	if dl.SkipScreenshots {
+		if is, msg := dl.ScreenshotRules.isScreenshot(cfg, imgType); is {
+			return false, msg
+		}
+	}
+	if dl.Filter != nil && dl.Filter.usesImage && !dl.Filter.Match(submission, cfg.Width, cfg.Height) {
+		return false, "filter mismatch"
+	}
+	if !dl.matchTargetImage(submission, cfg.Width, cfg.Height) {
+		return false, "filter mismatch"
+	}
+	// reads the whole file, so it comes last
+	if dl.StaticOnly {
+		if animated, err := isAnimated(d.file, imgType); err != nil {
+			return false, "failed to count frames"
+		} else if animated {
+			return false, "animated"
+		}
+	}
+	return true, ""
+}
+
+// checkVideo applies the size limits to videos, which checkImage can't decode, and skips them with -static-only
+// and -require-decodable. Filters that use image fields only match if the submission fields decide them.
+func (dl *Downloader) checkVideo(size int, submission Submission) (bool, string) {
+	if dl.StaticOnly || dl.RequireDecodable {
+		return false, "video"
+	}
+	if ok, msg := dl.checkSize(size, ""); !ok {
+		return false, msg
+	}
+	if dl.Filter != nil && dl.Filter.usesImage && !dl.Filter.MatchWithoutImage(submission) {
+		return false, "filter mismatch"
+	}
+	if !dl.matchTargetVideo(submission) {
+		return false, "filter mismatch"
+	}
+	return true, ""
+}
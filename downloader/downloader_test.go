@@ -0,0 +1,235 @@
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testDownload writes data to a file in dir and returns it as a finished download
+func testDownload(t *testing.T, dir string, data []byte) *download {
+	f, err := ioutil.TempFile(dir, "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := data
+	if len(header) > downloadHeaderSize {
+		header = header[:downloadHeaderSize]
+	}
+	return &download{file: f.Name(), header: header, size: len(data)}
+}
+
+func pngBytes(t *testing.T, width int, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func animatedGifBytes(t *testing.T, width int, height int) []byte {
+	frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func mustCompileFilter(t *testing.T, expr string) *Filter {
+	f, err := CompileFilter(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCheckImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	landscape := pngBytes(t, 400, 200)
+	portrait := pngBytes(t, 200, 400)
+	square := pngBytes(t, 300, 300)
+
+	tests := []struct {
+		name string
+		data []byte
+		// target of the submission, for the per-target filters
+		target string
+		setup  func(t *testing.T, dl *Downloader)
+		// want is the start of the rejection message, "" if the image passes
+		want string
+	}{
+		{name: "no filters", data: landscape},
+		{name: "size only for undecodable", data: []byte("not an image")},
+		{name: "min size", data: landscape, want: "smaller than", setup: func(t *testing.T, dl *Downloader) {
+			dl.MinSize = 1 << 20
+		}},
+		{name: "max size", data: landscape, want: "greater than", setup: func(t *testing.T, dl *Downloader) {
+			dl.MaxSize = 10
+		}},
+		{name: "max size of the type", data: landscape, want: "greater than 10 bytes", setup: func(t *testing.T, dl *Downloader) {
+			dl.MaxSize = 1 << 20
+			dl.MaxSizeTypes = map[string]int{"png": 10}
+		}},
+		{name: "max size of another type", data: landscape, setup: func(t *testing.T, dl *Downloader) {
+			dl.MaxSizeTypes = map[string]int{"jpeg": 10}
+		}},
+		{name: "undecodable", data: []byte("not an image"), want: "failed to parse image", setup: func(t *testing.T, dl *Downloader) {
+			dl.MinWidth = 1
+		}},
+		{name: "type not allowed", data: landscape, want: "type png not allowed", setup: func(t *testing.T, dl *Downloader) {
+			dl.SetTypes([]string{"jpg"})
+		}},
+		{name: "type allowed", data: landscape, setup: func(t *testing.T, dl *Downloader) {
+			dl.SetTypes([]string{"jpg", "png"})
+		}},
+		{name: "portrait", data: portrait, want: "portrait orientation", setup: func(t *testing.T, dl *Downloader) {
+			dl.SetOrientations([]string{"landscape"})
+		}},
+		{name: "landscape", data: landscape, want: "landscape orientation", setup: func(t *testing.T, dl *Downloader) {
+			dl.SetOrientations([]string{"portrait"})
+		}},
+		{name: "square", data: square, want: "square orientation", setup: func(t *testing.T, dl *Downloader) {
+			dl.SetOrientations([]string{"landscape", "portrait"})
+		}},
+		{name: "orientation allowed", data: portrait, setup: func(t *testing.T, dl *Downloader) {
+			dl.SetOrientations([]string{"portrait"})
+		}},
+		{name: "min width", data: landscape, want: "width < 500", setup: func(t *testing.T, dl *Downloader) {
+			dl.MinWidth = 500
+		}},
+		{name: "min height", data: landscape, want: "height < 300", setup: func(t *testing.T, dl *Downloader) {
+			dl.MinHeight = 300
+		}},
+		{name: "max width", data: landscape, want: "width > 300", setup: func(t *testing.T, dl *Downloader) {
+			dl.MaxWidth = 300
+		}},
+		{name: "max height", data: portrait, want: "height > 300", setup: func(t *testing.T, dl *Downloader) {
+			dl.MaxHeight = 300
+		}},
+		{name: "dimensions within the limits", data: landscape, setup: func(t *testing.T, dl *Downloader) {
+			dl.MinWidth, dl.MinHeight, dl.MaxWidth, dl.MaxHeight = 400, 200, 400, 200
+		}},
+		{name: "aspect ratio", data: portrait, want: "aspect ratio 2.00 > 1.50", setup: func(t *testing.T, dl *Downloader) {
+			dl.MaxAspect = 1.5
+		}},
+		{name: "screenshot", data: pngBytes(t, 100, 100), want: "tiny image", setup: func(t *testing.T, dl *Downloader) {
+			rules, err := ParseScreenshotRules(DefaultScreenshotRules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dl.SkipScreenshots = true
+			dl.ScreenshotRules = rules
+		}},
+		{name: "screenshot resolution", data: pngBytes(t, 1080, 1920), want: "screenshot resolution", setup: func(t *testing.T, dl *Downloader) {
+			rules, err := ParseScreenshotRules(DefaultScreenshotRules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dl.SkipScreenshots = true
+			dl.ScreenshotRules = rules
+		}},
+		{name: "filter", data: landscape, want: "filter mismatch", setup: func(t *testing.T, dl *Downloader) {
+			dl.Filter = mustCompileFilter(t, "width >= 1000")
+		}},
+		{name: "filter matches", data: landscape, setup: func(t *testing.T, dl *Downloader) {
+			dl.Filter = mustCompileFilter(t, "ratio < 1")
+		}},
+		{name: "target filter", data: landscape, target: "pics", want: "filter mismatch", setup: func(t *testing.T, dl *Downloader) {
+			dl.TargetConfigs = map[string]*TargetConfig{"pics": {filter: mustCompileFilter(t, "height >= 1000")}}
+		}},
+		{name: "filter of another target", data: landscape, target: "aww", setup: func(t *testing.T, dl *Downloader) {
+			dl.TargetConfigs = map[string]*TargetConfig{"pics": {filter: mustCompileFilter(t, "height >= 1000")}}
+		}},
+		{name: "animated", data: animatedGifBytes(t, 40, 20), want: "animated", setup: func(t *testing.T, dl *Downloader) {
+			dl.StaticOnly = true
+		}},
+		{name: "static", data: landscape, setup: func(t *testing.T, dl *Downloader) {
+			dl.StaticOnly = true
+		}},
+		{name: "not decodable", data: []byte("not an image"), want: "failed to parse image", setup: func(t *testing.T, dl *Downloader) {
+			dl.RequireDecodable = true
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dl := New()
+			if test.setup != nil {
+				test.setup(t, dl)
+			}
+			var submission Submission
+			submission.Target = test.target
+			ok, msg := dl.checkImage(testDownload(t, dir, test.data), submission)
+			if test.want == "" && !ok {
+				t.Errorf("rejected with %q, want it to pass", msg)
+			} else if test.want != "" && (ok || !strings.HasPrefix(msg, test.want)) {
+				t.Errorf("checkImage = %v, %q, want a rejection with %q", ok, msg, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckVideo(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(dl *Downloader)
+		score int
+		want  string
+	}{
+		{name: "no filters"},
+		{name: "static only", setup: func(dl *Downloader) { dl.StaticOnly = true }, want: "video"},
+		{name: "require decodable", setup: func(dl *Downloader) { dl.RequireDecodable = true }, want: "video"},
+		{name: "max size", setup: func(dl *Downloader) { dl.MaxSize = 10 }, want: "greater than 10 bytes"},
+		{name: "image dimensions don't apply", setup: func(dl *Downloader) { dl.MinWidth = 1000 }},
+		{name: "filter with image fields", setup: func(dl *Downloader) {
+			dl.Filter = mustCompileFilter(t, "score>100 && width>=1920")
+		}, score: 5, want: "filter mismatch"},
+		{name: "filter undecided without the image", setup: func(dl *Downloader) {
+			dl.Filter = mustCompileFilter(t, "score>100 && width>=1920")
+		}, score: 500, want: "filter mismatch"},
+		{name: "filter decided by the submission", setup: func(dl *Downloader) {
+			dl.Filter = mustCompileFilter(t, "score>100 || width>=1920")
+		}, score: 500},
+		{name: "filter without image fields", setup: func(dl *Downloader) {
+			dl.Filter = mustCompileFilter(t, "score>100")
+		}, score: 5},
+		{name: "target filter", setup: func(dl *Downloader) {
+			dl.TargetConfigs = map[string]*TargetConfig{"pics": {filter: mustCompileFilter(t, "score>100 && !(height<1080)")}}
+		}, score: 5, want: "filter mismatch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dl := New()
+			if test.setup != nil {
+				test.setup(dl)
+			}
+			var submission Submission
+			submission.Target = "pics"
+			submission.Score = test.score
+			ok, msg := dl.checkVideo(100, submission)
+			if ok != (test.want == "") || msg != test.want {
+				t.Errorf("checkVideo = %v, %q, want %q", ok, msg, test.want)
+			}
+		})
+	}
+}
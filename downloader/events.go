@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+)
+
+// DownloadEvent describes a written file or a skipped submission or image, see Downloader.OnDownload
+type DownloadEvent struct {
+	Submission Submission
+	// Url is the url of the image, or of the submission if the whole submission was skipped
+	Url string
+	// Path is the written file, "" for skips
+	Path string
+	// Hash is the hex sha256 of the written file, "" for skips, links and self post texts
+	Hash  string
+	Bytes int
+	// Width and Height are 0 if the file isn't an image that can be decoded
+	Width  int
+	Height int
+	// Skipped is set for skips, Reason is why, e.g. "duplicate" or "file exists"
+	Skipped bool
+	Reason  string
+	// Message is the log line of the event
+	Message string
+}
+
+// emit passes e to OnDownload if it is set
+func (dl *Downloader) emit(e DownloadEvent) {
+	if dl.OnDownload != nil {
+		dl.OnDownload(e)
+	}
+}
+
+// logSkip counts and emits routine skips (duplicates, filter mismatches, ...) of the image u of a submission
+func (dl *Downloader) logSkip(submission Submission, u string, reason string, format string, v ...interface{}) {
+	dl.countSkip(reason)
+	dl.emit(DownloadEvent{
+		Submission: submission,
+		Url:        u,
+		Skipped:    true,
+		Reason:     reason,
+		Message:    fmt.Sprintf(format, v...),
+	})
+}
+
+// logSave emits a written file, the message is formatted like a log line
+func (dl *Downloader) logSave(e DownloadEvent, format string, v ...interface{}) {
+	e.Message = fmt.Sprintf(format, v...)
+	dl.emit(e)
+}
+
+// saveEvent returns the event of the download d of u written to p
+func saveEvent(submission Submission, u string, p string, d *download) DownloadEvent {
+	e := DownloadEvent{
+		Submission: submission,
+		Url:        u,
+		Path:       p,
+		Hash:       hex.EncodeToString([]byte(d.sum)),
+		Bytes:      d.size,
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(d.header)); err == nil {
+		e.Width = cfg.Width
+		e.Height = cfg.Height
+	}
+	return e
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// jpegExif returns the TIFF structure of the EXIF segment of a JPEG from its header, or nil if it has none.
+func jpegExif(header []byte) []byte {
+	if len(header) < 4 || header[0] != 0xff || header[1] != 0xd8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(header); {
+		if header[i] != 0xff {
+			return nil
+		}
+		marker := header[i+1]
+		// start of scan, the headers are over
+		if marker == 0xda {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(header[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(header) {
+			return nil
+		}
+		segment := header[i+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// jpegDateTimeOriginal returns the capture time (DateTimeOriginal) of a JPEG from its header, or the zero time.
+// EXIF has no time zone, the time is interpreted as local time like the other template times.
+func jpegDateTimeOriginal(header []byte) time.Time {
+	tiff := jpegExif(header)
+	if len(tiff) < 8 {
+		return time.Time{}
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}
+	}
+	// DateTimeOriginal is in the Exif sub-IFD, which IFD0 points to
+	exifIfd, ok := exifEntry(tiff, order, order.Uint32(tiff[4:8]), 0x8769)
+	if !ok {
+		return time.Time{}
+	}
+	entry, ok := exifEntry(tiff, order, order.Uint32(tiff[exifIfd+8:exifIfd+12]), 0x9003)
+	if !ok {
+		return time.Time{}
+	}
+	// an ASCII value of "YYYY:MM:DD hh:mm:ss\x00", too long to be stored inline
+	count := order.Uint32(tiff[entry+4 : entry+8])
+	offset, ok := exifOffset(tiff, order.Uint32(tiff[entry+8:entry+12]), 19)
+	if count < 19 || !ok {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", string(tiff[offset:offset+19]), time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// exifOffset checks that n bytes at the offset v read from the EXIF data are within tiff and returns v as an int.
+// The check is done in uint64, offsets of 2^31 and more would be negative as an int on 32 bit platforms.
+func exifOffset(tiff []byte, v uint32, n int) (int, bool) {
+	if uint64(v)+uint64(n) > uint64(len(tiff)) {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// exifEntry returns the offset of the 12 byte entry with the given tag in the IFD at offset v
+func exifEntry(tiff []byte, order binary.ByteOrder, v uint32, tag uint16) (int, bool) {
+	ifd, ok := exifOffset(tiff, v, 2)
+	if !ok {
+		return 0, false
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:entry+2]) == tag {
+			return entry, true
+		}
+	}
+	return 0, false
+}
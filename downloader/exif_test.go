@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// exifJpeg returns the header of a JPEG with an APP1 segment holding the TIFF structure tiff
+func exifJpeg(tiff []byte) []byte {
+	header := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(header[4:], uint16(2+6+len(tiff)))
+	header = append(header, "Exif\x00\x00"...)
+	return append(header, tiff...)
+}
+
+// dateTiff returns a little endian TIFF structure with IFD0 at 8, the Exif IFD at 26 and DateTimeOriginal at 44,
+// but with the given offsets pointing to them
+func dateTiff(ifd0 uint32, exifIfd uint32, date uint32) []byte {
+	tiff := make([]byte, 64)
+	copy(tiff, "II\x2a\x00")
+	binary.LittleEndian.PutUint32(tiff[4:], ifd0)
+	// IFD0 with the pointer to the Exif IFD
+	binary.LittleEndian.PutUint16(tiff[8:], 1)
+	binary.LittleEndian.PutUint16(tiff[10:], 0x8769)
+	binary.LittleEndian.PutUint16(tiff[12:], 4)
+	binary.LittleEndian.PutUint32(tiff[14:], 1)
+	binary.LittleEndian.PutUint32(tiff[18:], exifIfd)
+	// Exif IFD with DateTimeOriginal
+	binary.LittleEndian.PutUint16(tiff[26:], 1)
+	binary.LittleEndian.PutUint16(tiff[28:], 0x9003)
+	binary.LittleEndian.PutUint16(tiff[30:], 2)
+	binary.LittleEndian.PutUint32(tiff[32:], 20)
+	binary.LittleEndian.PutUint32(tiff[36:], date)
+	copy(tiff[44:], "2020:01:02 03:04:05\x00")
+	return tiff
+}
+
+func TestJpegDateTimeOriginal(t *testing.T) {
+	want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	for _, test := range []struct {
+		name  string
+		tiff  []byte
+		valid bool
+	}{
+		{name: "valid", tiff: dateTiff(8, 26, 44), valid: true},
+		{name: "IFD0 out of range", tiff: dateTiff(0xfffffff0, 26, 44)},
+		{name: "Exif IFD out of range", tiff: dateTiff(8, 0xfffffff0, 44)},
+		{name: "date out of range", tiff: dateTiff(8, 26, 0xfffffff0)},
+		{name: "date at the end", tiff: dateTiff(8, 26, 50)},
+		{name: "truncated", tiff: dateTiff(8, 26, 44)[:30]},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := jpegDateTimeOriginal(exifJpeg(test.tiff))
+			if test.valid && !got.Equal(want) {
+				t.Errorf("read %v, want %v", got, want)
+			} else if !test.valid && !got.IsZero() {
+				t.Errorf("read %v from malformed EXIF data, want the zero time", got)
+			}
+		})
+	}
+}
+
+// orientationTiff returns a little endian TIFF structure with IFD0 at 8 holding the orientation, but with ifd0 pointing to it
+func orientationTiff(ifd0 uint32, orientation uint16) []byte {
+	tiff := make([]byte, 26)
+	copy(tiff, "II\x2a\x00")
+	binary.LittleEndian.PutUint32(tiff[4:], ifd0)
+	binary.LittleEndian.PutUint16(tiff[8:], 1)
+	binary.LittleEndian.PutUint16(tiff[10:], 0x0112)
+	binary.LittleEndian.PutUint16(tiff[12:], 3)
+	binary.LittleEndian.PutUint32(tiff[14:], 1)
+	binary.LittleEndian.PutUint16(tiff[18:], orientation)
+	return tiff
+}
+
+func TestJpegOrientation(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		header []byte
+		want   int
+	}{
+		{name: "valid", header: exifJpeg(orientationTiff(8, 6)), want: 6},
+		{name: "invalid orientation", header: exifJpeg(orientationTiff(8, 9))},
+		{name: "IFD0 out of range", header: exifJpeg(orientationTiff(0xfffffff0, 6))},
+		{name: "IFD0 at the end", header: exifJpeg(orientationTiff(25, 6))},
+		{name: "too many entries", header: exifJpeg(append(orientationTiff(8, 6)[:8], 0xff, 0xff))},
+		{name: "big endian", header: exifJpeg([]byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x03\x00\x00")), want: 3},
+		{name: "no exif", header: []byte{0xff, 0xd8, 0xff, 0xda}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := jpegOrientation(test.header); got != test.want {
+				t.Errorf("orientation %d, want %d", got, test.want)
+			}
+		})
+	}
+}
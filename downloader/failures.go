@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// FailedDownload is an entry of the -failures-file, Submission is the submission in the form of a reddit listing child
+type FailedDownload struct {
+	Url        string          `json:"url"`
+	Permalink  string          `json:"permalink"`
+	Reason     string          `json:"reason"`
+	Target     string          `json:"target,omitempty"`
+	Submission json.RawMessage `json:"submission"`
+}
+
+// logFetchFailure logs and counts a failed download of the image u of a submission like LogFailure
+// and remembers it for the FailuresFile
+func (dl *Downloader) logFetchFailure(submission Submission, u string, reason string, format string, v ...interface{}) {
+	dl.LogFailure(format, v...)
+	if dl.FailuresFile == "" {
+		return
+	}
+	data := submission.RawData
+	if len(data) == 0 {
+		var err error
+		data, err = json.Marshal(submission.SubmissionData)
+		if err != nil {
+			return
+		}
+	}
+	child, err := json.Marshal(struct {
+		Kind string          `json:"kind"`
+		Data json.RawMessage `json:"data"`
+	}{Kind: "t3", Data: data})
+	if err != nil {
+		return
+	}
+	dl.failedDownloads = append(dl.failedDownloads, FailedDownload{
+		Url:        u,
+		Permalink:  submission.Permalink,
+		Reason:     reason,
+		Target:     submission.Target,
+		Submission: child,
+	})
+}
+
+// writeFailuresFile writes the failed downloads of the run as a json array, which is empty if nothing failed
+func (dl *Downloader) writeFailuresFile() error {
+	failed := dl.failedDownloads
+	if failed == nil {
+		failed = make([]FailedDownload, 0)
+	}
+	data, err := json.MarshalIndent(failed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dl.FailuresFile, data, 0644)
+}
+
+// ReadFailuresFile reads a -failures-file and returns the submissions with failed downloads, each once,
+// to download them again
+func ReadFailuresFile(p string) ([]Submission, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var failed []FailedDownload
+	err = json.Unmarshal(data, &failed)
+	if err != nil {
+		return nil, err
+	}
+	var submissions []Submission
+	seen := make(map[string]struct{})
+	for _, f := range failed {
+		var submission Submission
+		err = json.Unmarshal(f.Submission, &submission)
+		if err != nil {
+			return nil, err
+		}
+		key := submission.Permalink + " " + submission.Url
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		submission.Target = f.Target
+		submissions = append(submissions, submission)
+	}
+	return submissions, nil
+}
@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultFastDedupBytes is the default number of leading bytes fingerprinted by -fast-dedup
+const DefaultFastDedupBytes = 64 * 1024
+
+// fingerprints are prefixed like pixel hashes, so they can't collide with file hashes
+const fingerprintPrefix = "head:"
+
+// fingerprint returns the sha256 of the size and the first FastDedupBytes of the image at u, which are fetched
+// with a range request. ok is false if the image can't be fetched or its size isn't known.
+func (dl *Downloader) fingerprint(u string) (fp string, ok bool) {
+	n := dl.FastDedupBytes
+	if n <= 0 {
+		n = DefaultFastDedupBytes
+	}
+	resp, cancel, err := dl.getImageRange(u, n)
+	if err != nil {
+		return "", false
+	}
+	// servers that ignore the range send the whole image, which isn't read
+	defer func() {
+		_ = resp.Body.Close()
+		cancel()
+	}()
+
+	// placeholders and errors are left to the full download, which reports them
+	if resp.StatusCode >= 300 || imgurRemoved(resp) {
+		return "", false
+	}
+
+	size := int64(-1)
+	if resp.StatusCode == http.StatusPartialContent {
+		size = contentRangeSize(resp.Header.Get("Content-Range"))
+	} else if resp.StatusCode == http.StatusOK {
+		size = resp.ContentLength
+	}
+	if size < 0 {
+		return "", false
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(n)))
+	if err != nil {
+		return "", false
+	}
+
+	hasher := sha256.New()
+	var sizeBytes [8]byte
+	binary.BigEndian.PutUint64(sizeBytes[:], uint64(size))
+	_, _ = hasher.Write(sizeBytes[:])
+	_, _ = hasher.Write(head)
+	return fingerprintPrefix + string(hasher.Sum(nil)), true
+}
+
+// contentRangeSize returns the complete length of a Content-Range header like "bytes 0-1023/4096", or -1 if it's unknown
+func contentRangeSize(header string) int64 {
+	i := strings.LastIndex(header, "/")
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// fastDuplicate fingerprints u for FastDedup and reports whether it's a duplicate, which was linked to linkPath
+// (if it isn't nil) or skipped.
+// fp is "" if FastDedup is off or u has no fingerprint, the full download decides then. New fingerprints are only
+// remembered by rememberFingerprint once the full download passed.
+func (dl *Downloader) fastDuplicate(u string, submission Submission, linkPath func(ext string) string) (fp string, duplicate bool) {
+	if !dl.FastDedup {
+		return "", false
+	}
+	fp, ok := dl.fingerprint(u)
+	if !ok {
+		return "", false
+	}
+	existing, exists := dl.knownHashes[fp]
+	if exists {
+		dl.refreshHash(fp)
+		if linkPath != nil && dl.HardlinkDuplicates && existing != "" {
+			dl.linkDuplicate(existing, linkPath(filepath.Ext(existing)), u, submission)
+			return fp, true
+		}
+		dl.logSkip(submission, u, "duplicate", "fetching %s (%s) => fingerprint exists already, skipping", u, submission.Permalink)
+		return fp, true
+	}
+	return fp, false
+}
+
+// rememberFingerprint records the fingerprint of an image written to p (or only hashed for OnlyNewHashes),
+// also in HashesFile, so later runs can skip it without downloading it
+func (dl *Downloader) rememberFingerprint(fp string, p string) {
+	if fp == "" {
+		return
+	}
+	dl.knownHashes[fp] = p
+	dl.rememberHash(fp)
+}
@@ -0,0 +1,484 @@
+package downloader
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a compiled -filter expression, e.g.
+// `score>100 && width>=1920 && !nsfw && flair~"OC"`.
+type Filter struct {
+	root filterNode
+	// usesImage is set if the expression references image fields, which are only known after the download
+	usesImage bool
+}
+
+type filterEnv struct {
+	submission Submission
+	width      int
+	height     int
+	// noImage is set for downloads without dimensions (videos), image fields evaluate to filterUnknown then
+	noImage bool
+}
+
+type filterUnknownValue struct{}
+
+// filterUnknown is the value of image fields without an image and of the expressions that depend on them
+var filterUnknown = filterUnknownValue{}
+
+type filterType int
+
+const (
+	filterNumber filterType = iota
+	filterString
+	filterBool
+)
+
+func (t filterType) String() string {
+	switch t {
+	case filterNumber:
+		return "number"
+	case filterString:
+		return "string"
+	default:
+		return "bool"
+	}
+}
+
+type filterField struct {
+	typ   filterType
+	image bool
+	get   func(env filterEnv) interface{}
+}
+
+var filterFields = map[string]filterField{
+	"score": {typ: filterNumber, get: func(env filterEnv) interface{} {
+		return float64(env.submission.Score)
+	}},
+	"nsfw": {typ: filterBool, get: func(env filterEnv) interface{} {
+		return env.submission.Nsfw
+	}},
+	"flair": {typ: filterString, get: func(env filterEnv) interface{} {
+		return env.submission.LinkFlairText
+	}},
+	"author": {typ: filterString, get: func(env filterEnv) interface{} {
+		return env.submission.Author
+	}},
+	"title": {typ: filterString, get: func(env filterEnv) interface{} {
+		return env.submission.Title
+	}},
+	"subreddit": {typ: filterString, get: func(env filterEnv) interface{} {
+		return env.submission.Subreddit
+	}},
+	"domain": {typ: filterString, get: func(env filterEnv) interface{} {
+		return env.submission.Domain
+	}},
+	"width": {typ: filterNumber, image: true, get: func(env filterEnv) interface{} {
+		return float64(env.width)
+	}},
+	"height": {typ: filterNumber, image: true, get: func(env filterEnv) interface{} {
+		return float64(env.height)
+	}},
+	"ratio": {typ: filterNumber, image: true, get: func(env filterEnv) interface{} {
+		if env.width == 0 {
+			return float64(0)
+		}
+		return float64(env.height) / float64(env.width)
+	}},
+}
+
+// CompileFilter parses a filter expression. Errors point at the offending token.
+func CompileFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	if root.typ() != filterBool {
+		return nil, fmt.Errorf("expression is a %s, not a bool", root.typ())
+	}
+	return &Filter{root: root, usesImage: p.usesImage}, nil
+}
+
+// Match evaluates the filter for a submission and (if known) its image dimensions.
+func (f *Filter) Match(submission Submission, width int, height int) bool {
+	return f.root.eval(filterEnv{submission: submission, width: width, height: height}) == true
+}
+
+// MatchWithoutImage evaluates the filter for a submission whose download has no dimensions, e.g. a video.
+// Expressions that can't be decided without the image fields don't match.
+func (f *Filter) MatchWithoutImage(submission Submission) bool {
+	return f.root.eval(filterEnv{submission: submission, noImage: true}) == true
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexFilter(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+		start := i
+		if unicode.IsSpace(ch) {
+			i++
+		} else if ch == '(' {
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: start})
+			i++
+		} else if ch == ')' {
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: start})
+			i++
+		} else if ch == '"' || ch == '\'' {
+			i++
+			var sb strings.Builder
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					sb.WriteRune(runes[i+1])
+					i += 2
+				} else if runes[i] == ch {
+					closed = true
+					i++
+					break
+				} else {
+					sb.WriteRune(runes[i])
+					i++
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start})
+		} else if unicode.IsDigit(ch) || ch == '.' || (ch == '-' && i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.')) {
+			// a leading - is part of the number, scores can be negative
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		} else if unicode.IsLetter(ch) || ch == '_' {
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		} else {
+			op := ""
+			if i+1 < len(runes) {
+				two := string(runes[i : i+2])
+				switch two {
+				case "&&", "||", ">=", "<=", "==", "!=":
+					op = two
+				}
+			}
+			if op == "" {
+				switch ch {
+				case '>', '<', '!', '~':
+					op = string(ch)
+				default:
+					return nil, fmt.Errorf("unexpected character %q at position %d", ch, start)
+				}
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(runes)})
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens    []token
+	pos       int
+	usesImage bool
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		tok := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != filterBool || right.typ() != filterBool {
+			return nil, fmt.Errorf("operands of || at position %d must be bool", tok.pos)
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		tok := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != filterBool || right.typ() != filterBool {
+			return nil, fmt.Errorf("operands of && at position %d must be bool", tok.pos)
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.peek().kind == tokOp && p.peek().text == "!" {
+		tok := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.typ() != filterBool {
+			return nil, fmt.Errorf("operand of ! at position %d must be bool", tok.pos)
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return left, nil
+	}
+	switch tok.text {
+	case ">", ">=", "<", "<=", "==", "!=", "~":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if left.typ() != right.typ() {
+		return nil, fmt.Errorf("cannot compare %s with %s at position %d", left.typ(), right.typ(), tok.pos)
+	}
+	switch tok.text {
+	case "~":
+		lit, ok := right.(literalNode)
+		if !ok || left.typ() != filterString {
+			return nil, fmt.Errorf("~ at position %d requires a string field and a string literal", tok.pos)
+		}
+		re, err := regexp.Compile(`(?i)` + lit.value.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %v", tok.pos, err)
+		}
+		return matchNode{left, re}, nil
+	case ">", ">=", "<", "<=":
+		if left.typ() != filterNumber {
+			return nil, fmt.Errorf("%s at position %d requires numbers", tok.text, tok.pos)
+		}
+	}
+	return compareNode{op: tok.text, left: left, right: right}, nil
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at position %d, got %q", closing.pos, closing.text)
+		}
+		return node, nil
+	case tokNumber:
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return literalNode{value: num, t: filterNumber}, nil
+	case tokString:
+		return literalNode{value: tok.text, t: filterString}, nil
+	case tokIdent:
+		if tok.text == "true" || tok.text == "false" {
+			return literalNode{value: tok.text == "true", t: filterBool}, nil
+		}
+		field, ok := filterFields[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q at position %d", tok.text, tok.pos)
+		}
+		if field.image {
+			p.usesImage = true
+		}
+		return fieldNode{field}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+}
+
+type filterNode interface {
+	typ() filterType
+	eval(env filterEnv) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+	t     filterType
+}
+
+func (n literalNode) typ() filterType                { return n.t }
+func (n literalNode) eval(env filterEnv) interface{} { return n.value }
+
+type fieldNode struct {
+	field filterField
+}
+
+func (n fieldNode) typ() filterType { return n.field.typ }
+func (n fieldNode) eval(env filterEnv) interface{} {
+	if n.field.image && env.noImage {
+		return filterUnknown
+	}
+	return n.field.get(env)
+}
+
+type notNode struct {
+	operand filterNode
+}
+
+func (n notNode) typ() filterType { return filterBool }
+func (n notNode) eval(env filterEnv) interface{} {
+	if b, ok := n.operand.eval(env).(bool); ok {
+		return !b
+	}
+	return filterUnknown
+}
+
+type andNode struct {
+	left, right filterNode
+}
+
+func (n andNode) typ() filterType { return filterBool }
+func (n andNode) eval(env filterEnv) interface{} {
+	l := n.left.eval(env)
+	if l == false {
+		return false
+	}
+	r := n.right.eval(env)
+	if r == false {
+		return false
+	}
+	if l == filterUnknown || r == filterUnknown {
+		return filterUnknown
+	}
+	return true
+}
+
+type orNode struct {
+	left, right filterNode
+}
+
+func (n orNode) typ() filterType { return filterBool }
+func (n orNode) eval(env filterEnv) interface{} {
+	l := n.left.eval(env)
+	if l == true {
+		return true
+	}
+	r := n.right.eval(env)
+	if r == true {
+		return true
+	}
+	if l == filterUnknown || r == filterUnknown {
+		return filterUnknown
+	}
+	return false
+}
+
+type matchNode struct {
+	left filterNode
+	re   *regexp.Regexp
+}
+
+func (n matchNode) typ() filterType { return filterBool }
+func (n matchNode) eval(env filterEnv) interface{} {
+	s, ok := n.left.eval(env).(string)
+	if !ok {
+		return filterUnknown
+	}
+	return n.re.MatchString(s)
+}
+
+type compareNode struct {
+	op          string
+	left, right filterNode
+}
+
+func (n compareNode) typ() filterType { return filterBool }
+func (n compareNode) eval(env filterEnv) interface{} {
+	l := n.left.eval(env)
+	r := n.right.eval(env)
+	if l == filterUnknown || r == filterUnknown {
+		return filterUnknown
+	}
+	switch n.op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	lf := l.(float64)
+	rf := r.(float64)
+	switch n.op {
+	case ">":
+		return lf > rf
+	case ">=":
+		return lf >= rf
+	case "<":
+		return lf < rf
+	default:
+		return lf <= rf
+	}
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	var submission Submission
+	submission.Score = -3
+	submission.Title = "Sunset OC"
+	submission.LinkFlairText = "OC"
+	submission.Subreddit = "pics"
+
+	tests := []struct {
+		expr      string
+		want      bool
+		usesImage bool
+	}{
+		{expr: "score > -5", want: true},
+		{expr: "score > -2", want: false},
+		{expr: "score>=-3 && score<=-3", want: true},
+		{expr: "score > -.5", want: false},
+		// && binds tighter than ||
+		{expr: "true || true && false", want: true},
+		{expr: "(true || true) && false", want: false},
+		{expr: "false && true || true", want: true},
+		{expr: "!nsfw", want: true},
+		{expr: "!nsfw && score < 0", want: true},
+		{expr: "!(nsfw || score < 0)", want: false},
+		{expr: "!!nsfw", want: false},
+		{expr: `title ~ "oc$"`, want: true},
+		{expr: `title ~ "^oc"`, want: false},
+		{expr: `flair == "OC" && subreddit != 'aww'`, want: true},
+		{expr: `author == ""`, want: true},
+		{expr: "width >= 1920 && ratio < 1", want: true, usesImage: true},
+		{expr: "height > 1080", want: false, usesImage: true},
+		{expr: "score > 0 || ratio < 1", want: true, usesImage: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			f, err := CompileFilter(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.usesImage != test.usesImage {
+				t.Errorf("usesImage %v, want %v", f.usesImage, test.usesImage)
+			}
+			if got := f.Match(submission, 1920, 1080); got != test.want {
+				t.Errorf("Match = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatchWithoutImage(t *testing.T) {
+	var submission Submission
+	submission.Score = 200
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "score > 100 || width >= 1920", want: true},
+		{expr: "score > 100 && width >= 1920", want: false},
+		{expr: "score < 100 && width >= 1920", want: false},
+		{expr: "!(width < 1920)", want: false},
+		{expr: "width < 1920", want: false},
+		{expr: "score > 100", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			f, err := CompileFilter(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.MatchWithoutImage(submission); got != test.want {
+				t.Errorf("MatchWithoutImage = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "score >", want: `unexpected "end of expression" at position 7`},
+		{expr: "score > 5 )", want: `unexpected ")" at position 10`},
+		{expr: "(score > 5", want: `expected ) at position 10, got "end of expression"`},
+		{expr: "score $ 1", want: `unexpected character '$' at position 6`},
+		{expr: "score - 5", want: `unexpected character '-' at position 6`},
+		{expr: `title == "a`, want: "unterminated string at position 9"},
+		{expr: "score > 1.2.3", want: `invalid number "1.2.3" at position 8`},
+		{expr: "foo > 1", want: `unknown field "foo" at position 0`},
+		{expr: `title ~ "("`, want: "invalid regular expression at position 6: error parsing regexp: missing closing ): `(?i)(`"},
+		{expr: "title ~ flair", want: "~ at position 6 requires a string field and a string literal"},
+		{expr: "score ~ 5", want: "~ at position 6 requires a string field and a string literal"},
+		{expr: `score > "a"`, want: "cannot compare number with string at position 6"},
+		{expr: `title > "a"`, want: "> at position 6 requires numbers"},
+		{expr: "!score", want: "operand of ! at position 0 must be bool"},
+		{expr: "score && nsfw", want: "operands of && at position 6 must be bool"},
+		{expr: "nsfw || title", want: "operands of || at position 5 must be bool"},
+		{expr: "score", want: "expression is a number, not a bool"},
+		{expr: "", want: `unexpected "end of expression" at position 0`},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := CompileFilter(test.expr)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
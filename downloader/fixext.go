@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// typeExtensions maps the format names of the image package to the extensions files of that type may have,
+// the first one is used when renaming
+var typeExtensions = map[string][]string{
+	"png":  {".png"},
+	"jpeg": {".jpg", ".jpeg"},
+	"gif":  {".gif"},
+	"webp": {".webp"},
+	"tiff": {".tiff", ".tif"},
+	"bmp":  {".bmp"},
+	"avif": {".avif"},
+	"heic": {".heic", ".heif"},
+}
+
+// FixExtensions renames the images below root whose extension doesn't match their content (e.g. a PNG saved as .jpg),
+// together with their -metadata-raw sidecar, and updates the galleries. It returns the number of renamed files.
+func FixExtensions(root string) (int, error) {
+	renamed := make(map[string]string)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// a sidecar that was renamed with its image
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if _, ok := imageExtensions[ext]; !ok {
+			if _, ok := typeExtensions[strings.TrimPrefix(ext, ".")]; !ok {
+				return nil
+			}
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, imgType, decodeErr := image.DecodeConfig(f)
+		_ = f.Close()
+		if decodeErr != nil {
+			log.Printf("fixing extension of %s => %v, skipping", p, decodeErr)
+			return nil
+		}
+		exts, ok := typeExtensions[imgType]
+		if !ok {
+			return nil
+		}
+		for _, e := range exts {
+			if e == ext {
+				return nil
+			}
+		}
+
+		np := strings.TrimSuffix(p, filepath.Ext(p)) + exts[0]
+		if _, err := os.Lstat(np); err == nil {
+			log.Printf("fixing extension of %s => %s exists already, skipping", p, np)
+			return nil
+		}
+		if err := os.Rename(p, np); err != nil {
+			return err
+		}
+		if _, err := os.Stat(p + ".json"); err == nil {
+			if err := os.Rename(p+".json", np+".json"); err != nil {
+				return err
+			}
+		}
+		log.Printf("fixing extension of %s => %s", p, np)
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		renamed[abs] = np
+		return nil
+	})
+	if err != nil {
+		return len(renamed), err
+	}
+	if len(renamed) > 0 {
+		err = fixGalleryPaths(root, renamed)
+	}
+	log.Printf("renamed %d images", len(renamed))
+	return len(renamed), err
+}
+
+// fixGalleryPaths updates the entries of every gallery below root whose image was renamed and regenerates its index.html
+func fixGalleryPaths(root string, renamed map[string]string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != galleryStateFile {
+			return nil
+		}
+		dir, err := filepath.Abs(filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var entries []GalleryEntry
+		err = json.Unmarshal(data, &entries)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i, e := range entries {
+			np, ok := renamed[filepath.Join(dir, filepath.FromSlash(e.Path))]
+			if !ok {
+				continue
+			}
+			abs, err := filepath.Abs(np)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, abs)
+			if err != nil {
+				return err
+			}
+			entries[i].Path = filepath.ToSlash(rel)
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		data, err = json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p, data, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		// the directory is named after the subreddit
+		return writeGallery(filepath.Base(dir), dir, nil)
+	})
+}
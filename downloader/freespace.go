@@ -0,0 +1,19 @@
+package downloader
+
+import "fmt"
+
+// CheckFreeSpace returns an error if one of the output roots has less than -min-free-space available.
+// Roots whose free space can't be determined are ignored.
+func (dl *Downloader) CheckFreeSpace() error {
+	if dl.MinFreeSpace <= 0 {
+		return nil
+	}
+	roots := append([]string{dl.OutputRoot}, dl.ExtraOutputRoots...)
+	for _, root := range roots {
+		free, ok := freeSpace(root)
+		if ok && free < uint64(dl.MinFreeSpace) {
+			return fmt.Errorf("only %d bytes free in %s, less than -min-free-space %d", free, root, dl.MinFreeSpace)
+		}
+	}
+	return nil
+}
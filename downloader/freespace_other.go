@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package downloader
+
+// freeSpace can't be determined on this platform, -min-free-space is ignored
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
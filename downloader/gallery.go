@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const galleryStateFile = "gallery.json"
+const galleryIndexFile = "index.html"
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>r/{{.Subreddit}}</title>
+<style>
+body { font-family: sans-serif; background: #1a1a1b; color: #d7dadc; }
+a { color: #4fbcff; }
+.entry { display: inline-block; width: 240px; margin: 8px; vertical-align: top; }
+.entry img { max-width: 240px; max-height: 240px; }
+</style>
+</head>
+<body>
+<h1>r/{{.Subreddit}}</h1>
+{{range .Entries}}<div class="entry">
+<a href="{{.Path}}"><img src="{{.Path}}" alt="{{.Title}}" loading="lazy"></a>
+<div>{{.Title}}</div>
+<div>{{.Score}} points · <a href="https://www.reddit.com{{.Permalink}}">comments</a></div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// GalleryEntry is a single image on a subreddit's index.html
+type GalleryEntry struct {
+	// Path is relative to the gallery directory, with forward slashes
+	Path       string
+	Title      string
+	Score      int
+	Permalink  string
+	CreatedUtc float64
+}
+
+func (dl *Downloader) addGalleryEntry(submission Submission, p string) {
+	if !dl.Gallery {
+		return
+	}
+	dl.galleryEntries[submission.Subreddit] = append(dl.galleryEntries[submission.Subreddit], GalleryEntry{
+		Path:       p,
+		Title:      submission.Title,
+		Score:      submission.Score,
+		Permalink:  submission.Permalink,
+		CreatedUtc: submission.CreatedUtc,
+	})
+}
+
+// writeGalleries merges the entries of this run with the gallery state of previous runs
+// and regenerates index.html for every subreddit that got new images.
+func (dl *Downloader) writeGalleries() {
+	for subreddit, entries := range dl.galleryEntries {
+		dir, err := filepath.Abs(filepath.Join(dl.OutputRoot, subreddit))
+		if err != nil {
+			log.Printf("writing gallery for r/%s => %v", subreddit, err)
+			continue
+		}
+		err = writeGallery(subreddit, dir, entries)
+		if err != nil {
+			log.Printf("writing gallery for r/%s => %v", subreddit, err)
+		}
+	}
+}
+
+func writeGallery(subreddit string, dir string, entries []GalleryEntry) error {
+	merged := make(map[string]GalleryEntry)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, galleryStateFile))
+	if err == nil {
+		var previous []GalleryEntry
+		err = json.Unmarshal(data, &previous)
+		if err != nil {
+			return err
+		}
+		for _, e := range previous {
+			// drop images that were deleted since the last run
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(e.Path))); err == nil {
+				merged[e.Path] = e
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, e := range entries {
+		abs, err := filepath.Abs(e.Path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil {
+			return err
+		}
+		e.Path = filepath.ToSlash(rel)
+		merged[e.Path] = e
+	}
+
+	list := make([]GalleryEntry, 0, len(merged))
+	for _, e := range merged {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedUtc != list[j].CreatedUtc {
+			return list[i].CreatedUtc > list[j].CreatedUtc
+		}
+		return list[i].Path < list[j].Path
+	})
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, galleryStateFile), data, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, galleryIndexFile))
+	if err != nil {
+		return err
+	}
+	err = galleryTemplate.Execute(f, struct {
+		Subreddit string
+		Entries   []GalleryEntry
+	}{
+		Subreddit: subreddit,
+		Entries:   list,
+	})
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gifToMp4 transcodes a downloaded GIF to an mp4 with ffmpeg for -gif-to-mp4 and reports whether it did.
+// The GIF is kept when ffmpeg isn't installed or fails.
+func (dl *Downloader) gifToMp4(d *download, u string, submission Submission) bool {
+	if !bytes.HasPrefix(d.header, []byte("GIF8")) {
+		return false
+	}
+	ffmpeg, ok := dl.findFfmpeg()
+	if !ok {
+		return false
+	}
+	if err := transcodeGif(ffmpeg, d); err != nil {
+		log.Printf("converting %s (%s) to mp4 => %v, keeping the gif", u, submission.Permalink, err)
+		return false
+	}
+	return true
+}
+
+// findFfmpeg looks up ffmpeg in the PATH once and warns if it is missing
+func (dl *Downloader) findFfmpeg() (string, bool) {
+	if !dl.ffmpegChecked {
+		dl.ffmpegChecked = true
+		p, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			log.Printf("-gif-to-mp4 => %v, keeping gifs", err)
+		}
+		dl.ffmpeg = p
+	}
+	return dl.ffmpeg, dl.ffmpeg != ""
+}
+
+// transcodeGif replaces the downloaded GIF with an mp4. The dimensions are rounded down to even numbers,
+// which yuv420p requires and which keeps the video playable in browsers.
+func transcodeGif(ffmpeg string, d *download) error {
+	out, err := createTemp(filepath.Dir(d.file))
+	if err != nil {
+		return err
+	}
+	_ = out.Close()
+	cmd := exec.Command(ffmpeg, "-y", "-loglevel", "error", "-i", d.file,
+		"-movflags", "+faststart", "-pix_fmt", "yuv420p", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-f", "mp4", out.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(out.Name())
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	if err := os.Rename(out.Name(), d.file); err != nil {
+		_ = os.Remove(out.Name())
+		return err
+	}
+
+	f, err := os.Open(d.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	header := &headerWriter{limit: downloadHeaderSize}
+	n, err := io.Copy(io.MultiWriter(hasher, header), f)
+	if err != nil {
+		return err
+	}
+	d.size = int(n)
+	d.sum = string(hasher.Sum(nil))
+	d.header = header.data
+	return nil
+}
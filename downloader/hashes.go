@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadHashesFile loads the content hashes remembered by earlier runs as already downloaded, a missing file is empty.
+// Lines are "<unix time of the last sighting>\t<hex hash>" with hashes as in the dedupe report (pixel hashes with
+// -dedupe-pixels), older files without the time count as seen now. With DedupeWindow, hashes last seen before the
+// window are dropped. The file is rewritten with one line per hash if it had more, e.g. after refreshHash.
+func (dl *Downloader) ReadHashesFile(p string) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	now := time.Now()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		lines++
+		seen := now
+		if i := strings.Index(text, "\t"); i > 0 {
+			sec, err := strconv.ParseInt(text[:i], 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			seen = time.Unix(sec, 0)
+			text = text[i+1:]
+		}
+		hash, err := hex.DecodeString(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if last, ok := dl.seenHashes[string(hash)]; !ok || seen.After(last) {
+			dl.seenHashes[string(hash)] = seen
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if dl.DedupeWindow > 0 {
+		for hash, last := range dl.seenHashes {
+			if now.Sub(last) > dl.DedupeWindow {
+				delete(dl.seenHashes, hash)
+			}
+		}
+	}
+	for hash := range dl.seenHashes {
+		if _, ok := dl.knownHashes[hash]; !ok {
+			// the path is unknown, so duplicates of it can't be linked
+			dl.knownHashes[hash] = ""
+		}
+	}
+	if lines == len(dl.seenHashes) || !dl.WritesFiles() && !dl.OnlyNewHashes {
+		return nil
+	}
+	return dl.rewriteHashesFile(p)
+}
+
+// rewriteHashesFile replaces the hashes file with one line per remembered hash
+func (dl *Downloader) rewriteHashesFile(p string) error {
+	var data bytes.Buffer
+	for hash, last := range dl.seenHashes {
+		_, _ = fmt.Fprintf(&data, "%d\t%s\n", last.Unix(), hex.EncodeToString([]byte(hash)))
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// rememberHash appends the (binary) hash of a download to HashesFile with the current time
+func (dl *Downloader) rememberHash(hash string) {
+	if dl.HashesFile == "" || dl.Probe || dl.CheckTemplate {
+		return
+	}
+	now := time.Now()
+	dl.seenHashes[hash] = now
+	f, err := os.OpenFile(dl.HashesFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = fmt.Fprintf(f, "%d\t%s\n", now.Unix(), hex.EncodeToString([]byte(hash)))
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("writing %s => %v", dl.HashesFile, err)
+	}
+}
+
+// refreshHash renews the last sighting of a duplicate's hash in HashesFile, so hashes that keep coming back stay
+// within DedupeWindow. Without a window the time doesn't matter and nothing is written.
+func (dl *Downloader) refreshHash(hash string) {
+	if dl.DedupeWindow <= 0 {
+		return
+	}
+	if _, ok := dl.seenHashes[hash]; !ok {
+		// not persisted, e.g. a duplicate within the run without -hashes-file
+		return
+	}
+	dl.rememberHash(hash)
+}
+
+// recordNewHash remembers the hash of a download that passed the filters for OnlyNewHashes instead of writing it.
+// Duplicates were already skipped, so the hash is new.
+func (dl *Downloader) recordNewHash(submission Submission, u string, d *download) {
+	dl.newHashes++
+	dl.rememberHash(d.hash)
+	log.Printf("fetching %s (%s) => new hash %s", u, submission.Permalink, hex.EncodeToString([]byte(d.hash)))
+}
@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// AVIF and HEIC are both HEIF containers. Only the dimensions are extracted (from the ispe property),
+// which is enough for the filters; decoding the pixels would require an AV1/HEVC decoder.
+
+var errHeifDecode = errors.New("heif: decoding is not supported, only dimensions")
+
+// the meta box is at the start of the file, so reading this much is enough
+const heifHeaderLimit = 1 << 20
+
+func init() {
+	for _, brand := range []string{"avif", "avis"} {
+		image.RegisterFormat("avif", "????ftyp"+brand, decodeHeif, decodeHeifConfig)
+	}
+	for _, brand := range []string{"heic", "heix", "heim", "heis", "mif1"} {
+		image.RegisterFormat("heic", "????ftyp"+brand, decodeHeif, decodeHeifConfig)
+	}
+}
+
+func decodeHeif(r io.Reader) (image.Image, error) {
+	return nil, errHeifDecode
+}
+
+func decodeHeifConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, heifHeaderLimit))
+	if err != nil {
+		return image.Config{}, err
+	}
+	meta := findHeifBox(data, "meta")
+	// meta is a full box, skip version and flags
+	if len(meta) < 4 {
+		return image.Config{}, errors.New("heif: no meta box")
+	}
+	ipco := findHeifBox(findHeifBox(meta[4:], "iprp"), "ipco")
+	if ipco == nil {
+		return image.Config{}, errors.New("heif: no item properties")
+	}
+
+	// there is one ispe per item (including thumbnails and grid tiles), the largest one is the image itself
+	var width, height uint32
+	for rest := ipco; len(rest) > 0; {
+		boxType, content, next, ok := nextHeifBox(rest)
+		if !ok {
+			break
+		}
+		if boxType == "ispe" && len(content) >= 12 {
+			w := binary.BigEndian.Uint32(content[4:8])
+			h := binary.BigEndian.Uint32(content[8:12])
+			if uint64(w)*uint64(h) > uint64(width)*uint64(height) {
+				width = w
+				height = h
+			}
+		}
+		rest = next
+	}
+	if width == 0 || height == 0 {
+		return image.Config{}, errors.New("heif: no image spatial extents")
+	}
+	return image.Config{
+		ColorModel: color.YCbCrModel,
+		Width:      int(width),
+		Height:     int(height),
+	}, nil
+}
+
+// findHeifBox returns the content of the first box with the given type in data, or nil.
+func findHeifBox(data []byte, want string) []byte {
+	for len(data) > 0 {
+		boxType, content, next, ok := nextHeifBox(data)
+		if !ok {
+			return nil
+		}
+		if boxType == want {
+			return content
+		}
+		data = next
+	}
+	return nil
+}
+
+func nextHeifBox(data []byte) (boxType string, content []byte, rest []byte, ok bool) {
+	if len(data) < 8 {
+		return "", nil, nil, false
+	}
+	size := uint64(binary.BigEndian.Uint32(data[0:4]))
+	boxType = string(data[4:8])
+	header := uint64(8)
+	if size == 1 {
+		if len(data) < 16 {
+			return "", nil, nil, false
+		}
+		size = binary.BigEndian.Uint64(data[8:16])
+		header = 16
+	} else if size == 0 {
+		size = uint64(len(data))
+	}
+	if size < header || size > uint64(len(data)) {
+		return "", nil, nil, false
+	}
+	return boxType, data[header:size], data[size:], true
+}
@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+)
+
+const defaultImgurBaseUrl = "https://imgur.com"
+const defaultImgurApiUrl = "https://api.imgur.com"
+
+type ImgurClient struct {
+	http *http.Client
+	// baseUrl defaults to https://imgur.com, tests can point it at a local server
+	baseUrl string
+	// apiUrl defaults to https://api.imgur.com, the official api needs clientId
+	apiUrl   string
+	clientId string
+}
+
+func (i ImgurClient) base() string {
+	if i.baseUrl == "" {
+		return defaultImgurBaseUrl
+	}
+	return i.baseUrl
+}
+
+func (i ImgurClient) api() string {
+	if i.apiUrl == "" {
+		return defaultImgurApiUrl
+	}
+	return i.apiUrl
+}
+
+// GetAlbum fetches the images of an album. Without all=true the endpoint only returns the first images of
+// large albums, Count is always the number of images in the album.
+func (i ImgurClient) GetAlbum(id string) (Album, error) {
+	u := fmt.Sprintf(`%s/ajaxalbums/getimages/%s/hit.json?all=true`, i.base(), id)
+	var album Album
+	err := i.getJSON(u, &album)
+	return album, err
+}
+
+// GetGalleryItem fetches a gallery post (imgur.com/gallery/<id>), which is either an album or a single image.
+// With a client id it asks the official api, the legacy gallery page is only the fallback if that fails.
+func (i ImgurClient) GetGalleryItem(id string) (GalleryItem, error) {
+	if i.clientId != "" {
+		item, err := i.getApiGalleryItem(id)
+		if err == nil {
+			return item, nil
+		}
+		log.Printf("looking up imgur gallery %s => %v, falling back to the gallery page", id, err)
+	}
+	u := fmt.Sprintf(`%s/gallery/%s.json`, i.base(), id)
+	var item GalleryItem
+	err := i.getJSON(u, &item)
+	if err == nil && item.Image.Hash == "" {
+		err = fmt.Errorf("no gallery item %s (status %d)", id, item.Status)
+	}
+	return item, err
+}
+
+// getApiGalleryItem fetches a gallery post from the official api (/3/gallery/<id>), which returns the album or
+// image itself instead of the GalleryImage of the gallery page
+func (i ImgurClient) getApiGalleryItem(id string) (GalleryItem, error) {
+	var apiItem ImgurGalleryItem
+	err := i.getJSON(fmt.Sprintf(`%s/3/gallery/%s`, i.api(), id), &apiItem)
+	if err != nil {
+		return GalleryItem{}, err
+	}
+	if apiItem.Id == "" {
+		return GalleryItem{}, fmt.Errorf("no gallery item %s (status %d)", id, apiItem.Status)
+	}
+	item := GalleryItem{Success: apiItem.Success, Status: apiItem.Status}
+	item.Image = GalleryImage{Hash: apiItem.Id, IsAlbum: apiItem.IsAlbum, Animated: apiItem.Animated}
+	if !apiItem.IsAlbum {
+		item.Image.Ext = path.Ext(apiItem.Link)
+	}
+	return item, nil
+}
+
+// GetImage fetches the metadata of a single image (imgur.com/<hash>) from the official api, which tells
+// whether it is a video. It needs a client id (see -imgur-client-id).
+func (i ImgurClient) GetImage(hash string) (ImgurImage, error) {
+	var image ImgurImage
+	err := i.getJSON(fmt.Sprintf(`%s/3/image/%s`, i.api(), hash), &image)
+	if err == nil && image.Link == "" {
+		err = fmt.Errorf("no image %s (status %d)", hash, image.Status)
+	}
+	return image, err
+}
+
+func (i ImgurClient) getJSON(u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "reddit image downloader")
+	if i.clientId != "" {
+		req.Header.Set("Authorization", "Client-ID "+i.clientId)
+	}
+
+	resp, err := i.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("error closing response body: %v", err)
+		}
+	}()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+type Album struct {
+	AlbumData `json:"data"`
+	Success   bool
+	Status    int
+}
+
+type AlbumData struct {
+	Count  int
+	Images []AlbumImage
+}
+
+type ImgurImage struct {
+	ImgurImageData `json:"data"`
+	Success        bool
+	Status         int
+}
+
+type ImgurImageData struct {
+	// Type is the mime type, e.g. image/jpeg or video/mp4
+	Type     string
+	Animated bool
+	// Link is the url of the image in its original format, Mp4 is only set for animated images
+	Link string
+	Mp4  string
+}
+
+// ImgurGalleryItem is the response of the official gallery api, an album or an image
+type ImgurGalleryItem struct {
+	ImgurGalleryItemData `json:"data"`
+	Success              bool
+	Status               int
+}
+
+type ImgurGalleryItemData struct {
+	// Id is the album id or the image hash
+	Id       string
+	IsAlbum  bool `json:"is_album"`
+	Animated bool
+	// Link is the url of the image, or of the album page
+	Link string
+}
+
+type GalleryItem struct {
+	GalleryItemData `json:"data"`
+	Success         bool
+	Status          int
+}
+
+type GalleryItemData struct {
+	Image GalleryImage
+}
+
+type GalleryImage struct {
+	// Hash is the album id if IsAlbum is set, the image hash otherwise
+	Hash     string
+	Ext      string
+	IsAlbum  bool `json:"is_album"`
+	Animated bool
+}
+
+type AlbumImage struct {
+	Hash     string
+	Title    string
+	Ext      string
+	Datetime string
+	Animated bool
+	// Mp4 is only set by the official api, otherwise the mp4 url is derived from the hash
+	Mp4 string
+	// Url is only set for images of reddit galleries, imgur images are at i.imgur.com/<Hash><Ext>
+	Url string `json:"-"`
+}
@@ -0,0 +1,194 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// albumJson returns an ajax album response with n images of an album with count images
+func albumJson(n int, count int) string {
+	images := make([]string, n)
+	for i := range images {
+		images[i] = fmt.Sprintf(`{"hash":"img%03d","title":"","ext":".jpg","datetime":"2015-03-28 13:46:20","animated":false}`, i)
+	}
+	return fmt.Sprintf(`{"data":{"count":%d,"images":[%s]},"success":true,"status":200}`, count, strings.Join(images, ","))
+}
+
+func TestGetAlbumDecodesImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ajaxalbums/getimages/abc/hit.json" || r.URL.Query().Get("all") != "true" {
+			t.Errorf("requested %s", r.URL.String())
+		}
+		_, _ = w.Write([]byte(`{"data":{"count":2,"images":[
+			{"hash":"one","title":"first","ext":".jpg","datetime":"2015-03-28 13:46:20","animated":false},
+			{"hash":"two","title":"","ext":".gif","datetime":"2015-03-28 13:46:21","animated":true}
+		]},"success":true,"status":200}`))
+	}))
+	defer server.Close()
+
+	client := ImgurClient{http: http.DefaultClient, baseUrl: server.URL}
+	album, err := client.GetAlbum("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if album.Count != 2 || len(album.Images) != 2 {
+		t.Fatalf("decoded %+v", album)
+	}
+	first, second := album.Images[0], album.Images[1]
+	if first.Hash != "one" || first.Title != "first" || first.Ext != ".jpg" || first.Datetime != "2015-03-28 13:46:20" || first.Animated {
+		t.Errorf("first image %+v", first)
+	}
+	if second.Hash != "two" || second.Ext != ".gif" || !second.Animated {
+		t.Errorf("second image %+v", second)
+	}
+	if albumImageUrl(first) != "https://i.imgur.com/one.jpg" {
+		t.Errorf("url of the first image %s", albumImageUrl(first))
+	}
+}
+
+func TestGetGalleryItem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gallery/abc.json" {
+			_, _ = w.Write([]byte(`{"data":{"image":{"hash":"xyz","ext":".jpg","is_album":true}},"success":true,"status":200}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"error":"not found"},"success":false,"status":404}`))
+	}))
+	defer server.Close()
+
+	client := ImgurClient{http: http.DefaultClient, baseUrl: server.URL}
+	item, err := client.GetGalleryItem("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Image.Hash != "xyz" || !item.Image.IsAlbum {
+		t.Errorf("decoded %+v", item.Image)
+	}
+	if _, err := client.GetGalleryItem("missing"); err == nil {
+		t.Error("no error for a missing gallery item")
+	}
+}
+
+func TestGetGalleryItemFromApi(t *testing.T) {
+	legacy := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/3/gallery/abc":
+			if r.Header.Get("Authorization") != "Client-ID id" {
+				t.Errorf("authorization %q", r.Header.Get("Authorization"))
+			}
+			_, _ = w.Write([]byte(`{"data":{"id":"abc","is_album":true,"link":"https://imgur.com/a/abc"},"success":true,"status":200}`))
+		case "/3/gallery/img":
+			_, _ = w.Write([]byte(`{"data":{"id":"img","is_album":false,"animated":true,"link":"https://i.imgur.com/img.gif"},"success":true,"status":200}`))
+		case "/3/gallery/old":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"data":{"error":"not found"},"success":false,"status":404}`))
+		case "/gallery/old.json":
+			legacy++
+			_, _ = w.Write([]byte(`{"data":{"image":{"hash":"xyz","ext":".jpg","is_album":false}},"success":true,"status":200}`))
+		default:
+			t.Errorf("requested %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := ImgurClient{http: http.DefaultClient, baseUrl: server.URL, apiUrl: server.URL, clientId: "id"}
+	for _, test := range []struct {
+		id   string
+		want GalleryImage
+	}{
+		{id: "abc", want: GalleryImage{Hash: "abc", IsAlbum: true}},
+		{id: "img", want: GalleryImage{Hash: "img", Ext: ".gif", Animated: true}},
+		// the api doesn't know it, the gallery page does
+		{id: "old", want: GalleryImage{Hash: "xyz", Ext: ".jpg"}},
+	} {
+		item, err := client.GetGalleryItem(test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Image != test.want {
+			t.Errorf("gallery item %s decoded as %+v, want %+v", test.id, item.Image, test.want)
+		}
+	}
+	if legacy != 1 {
+		t.Errorf("%d requests of the gallery page, want only the fallback", legacy)
+	}
+}
+
+func TestGetAlbumLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("all") != "true" {
+			// without all=true imgur only returns the first images
+			_, _ = w.Write([]byte(albumJson(10, 300)))
+			return
+		}
+		_, _ = w.Write([]byte(albumJson(300, 300)))
+	}))
+	defer server.Close()
+
+	client := ImgurClient{http: http.DefaultClient, baseUrl: server.URL}
+	album, err := client.GetAlbum("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if album.Count != 300 || len(album.Images) != 300 {
+		t.Fatalf("decoded %d images of %d, want all 300", len(album.Images), album.Count)
+	}
+	for i, img := range album.Images {
+		if want := fmt.Sprintf("img%03d", i); img.Hash != want || img.Ext != ".jpg" {
+			t.Fatalf("image %d is %+v, want hash %s", i, img, want)
+		}
+	}
+}
+
+func TestFetchImgurWarnsAboutMissingImages(t *testing.T) {
+	for _, test := range []struct {
+		returned int
+		count    int
+		warns    bool
+	}{
+		{returned: 300, count: 320, warns: true},
+		{returned: 300, count: 300, warns: false},
+	} {
+		t.Run(fmt.Sprintf("%d of %d", test.returned, test.count), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(albumJson(test.returned, test.count)))
+			}))
+			defer server.Close()
+
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+
+			dl := New()
+			dl.imgurClient.baseUrl = server.URL
+			// skip the album after fetching it, nothing is downloaded
+			dl.MaxAlbumImages = 1
+			var skipped string
+			dl.OnDownload = func(e DownloadEvent) {
+				skipped = e.Message
+			}
+			var submission Submission
+			submission.Url = "https://imgur.com/a/large"
+			submission.Permalink = "/r/pics/comments/x/large/"
+			if err := dl.FetchImgur(submission); err != nil {
+				t.Fatal(err)
+			}
+
+			warning := fmt.Sprintf("imgur returned %d of %d images", test.returned, test.count)
+			if strings.Contains(logged.String(), warning) != test.warns {
+				t.Errorf("log %q, want the warning %q: %v", logged.String(), warning, test.warns)
+			}
+			// the album is counted with the size imgur reports
+			if want := fmt.Sprintf("(has %d)", test.count); !strings.Contains(skipped, want) {
+				t.Errorf("skipped with %q, want it to contain %q", skipped, want)
+			}
+		})
+	}
+}
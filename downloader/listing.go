@@ -0,0 +1,404 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lister fetches the listings of all targets page by page and sends their submissions to the download loop.
+type Lister struct {
+	// mu guards the targets, which can be replaced with SetTargets while running, and the state of the targets,
+	// which is updated by up to concurrency fetches at a time
+	mu      sync.Mutex
+	targets []string
+	removed []string
+
+	dl *Downloader
+
+	PageSize int
+	// MaxPages is the maximum number of pages per target (0 = off)
+	MaxPages int
+	// Search searches the subreddits instead of listing their newest submissions if set
+	Search           *string
+	SubredditTimeout time.Duration
+	// FailFastOnAuth exits on authentication failures instead of abandoning the target
+	FailFastOnAuth bool
+	// Concurrency is the number of targets whose pages are fetched at the same time
+	Concurrency int
+	// Rng shuffles the submissions of every page if set (only for Run)
+	Rng *rand.Rand
+
+	// the state of a target, missing entries are the state of a new target
+	after     map[string]string
+	completed map[string]bool
+	pages     map[string]int
+	// time spent fetching listings per target, for -subreddit-timeout
+	spent map[string]time.Duration
+	// quarantined subreddits an opt-in was attempted for
+	optedIn map[string]bool
+	// err stopped all targets, see Err
+	err error
+}
+
+// Err returns the authentication error that stopped the listing of all targets with FailFastOnAuth, or nil.
+// It must only be checked once the submissions channel is closed.
+func (l *Lister) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// NewLister returns a Lister of the targets (see NormalizeTarget) that fetches one page at a time.
+func (dl *Downloader) NewLister(targets []string) *Lister {
+	return &Lister{
+		dl:          dl,
+		targets:     targets,
+		Concurrency: 1,
+		after:       make(map[string]string),
+		completed:   make(map[string]bool),
+		pages:       make(map[string]int),
+		spent:       make(map[string]time.Duration),
+		optedIn:     make(map[string]bool),
+	}
+}
+
+// SetTargets replaces the targets, e.g. on SIGHUP. New targets start at the first page,
+// removed targets are dropped once their current page is done and start over if they are added again.
+func (l *Lister) SetTargets(targets []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setTargets(targets)
+}
+
+// SetTargetConfigs replaces the TargetConfigs of the Downloader together with the targets, e.g. on SIGHUP.
+func (l *Lister) SetTargetConfigs(targets []string, configs map[string]*TargetConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dl.configMu.Lock()
+	l.dl.TargetConfigs = configs
+	l.dl.configMu.Unlock()
+	l.setTargets(targets)
+}
+
+func (l *Lister) setTargets(targets []string) {
+	current := make(map[string]bool)
+	for _, target := range targets {
+		current[target] = true
+	}
+	for _, target := range l.targets {
+		if !current[target] {
+			l.removed = append(l.removed, target)
+		}
+	}
+	l.targets = targets
+}
+
+// currentTargets returns a copy of the targets and resets the state of removed ones.
+func (l *Lister) currentTargets() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, target := range l.removed {
+		delete(l.after, target)
+		delete(l.completed, target)
+		delete(l.pages, target)
+		delete(l.spent, target)
+	}
+	l.removed = nil
+	return append([]string(nil), l.targets...)
+}
+
+// newestFirst reports whether the listing of target is sorted by creation time, newest first.
+// The search is only applied to subreddits.
+func (l *Lister) newestFirst(target string, search *string) bool {
+	if strings.HasPrefix(target, domainPrefix) {
+		return l.dl.DomainSort == "new"
+	} else if strings.HasPrefix(target, userPrefix) {
+		return true
+	}
+	return search == nil || l.dl.SearchSort == "new"
+}
+
+// optInQuarantine accepts the quarantine of a subreddit target for -accept-quarantine, once per target.
+// It reports whether fetching the listing again can succeed.
+func (l *Lister) optInQuarantine(target string) bool {
+	if strings.HasPrefix(target, domainPrefix) || strings.HasPrefix(target, userPrefix) {
+		return false
+	}
+	l.mu.Lock()
+	attempted := l.optedIn[target]
+	l.optedIn[target] = true
+	l.mu.Unlock()
+	if attempted {
+		return false
+	}
+	err := l.dl.redditClient.QuarantineOptIn(target)
+	if err != nil {
+		log.Printf("opting in to quarantined %s => %v", describeTarget(target), err)
+		return false
+	}
+	log.Printf("opting in to quarantined %s => accepted", describeTarget(target))
+	return true
+}
+
+func (l *Lister) isCompleted(target string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err != nil || l.completed[target]
+}
+
+// nextPage returns the number of the next page of target, or false if the target is completed or has reached maxPages.
+func (l *Lister) nextPage(target string) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil || l.completed[target] {
+		return 0, false
+	}
+	if l.MaxPages > 0 && l.pages[target] >= l.MaxPages {
+		l.completed[target] = true
+		return 0, false
+	}
+	l.pages[target]++
+	return l.pages[target], true
+}
+
+// fetchPage fetches the next page of target, retrying until it succeeds or -subreddit-timeout is exceeded.
+// Meta submissions are removed. ok is false if the target was abandoned.
+func (l *Lister) fetchPage(target string, page int) (children []Submission, ok bool) {
+	l.dl.pause.wait()
+	<-l.dl.throttler
+	if l.Err() != nil {
+		// pages that were started before another target failed with FailFastOnAuth
+		return nil, false
+	}
+	log.Printf("fetching page %d on %s", page, describeTarget(target))
+
+	l.mu.Lock()
+	after := l.after[target]
+	spent := l.spent[target]
+	l.mu.Unlock()
+
+	search := l.Search
+	if c := l.dl.targetConfig(target); c != nil && c.Search != "" {
+		search = &c.Search
+	}
+
+	var listing Listing
+	var err error
+
+	var backoff time.Duration
+	fetchStart := time.Now()
+	for {
+		listing, err = l.dl.fetchListing(target, after, l.PageSize, search)
+		if authErr, ok := err.(*AuthError); ok {
+			if authErr.Reason == "quarantined" && l.dl.AcceptQuarantine && l.optInQuarantine(target) {
+				<-l.dl.throttler
+				continue
+			}
+			if authErr.Reason == "" && l.FailFastOnAuth {
+				// stop all targets, the download loop ends and reports it after the run was finished properly
+				l.mu.Lock()
+				if l.err == nil {
+					l.err = fmt.Errorf("fetching %s => %v, check the credentials", describeTarget(target), err)
+				}
+				l.mu.Unlock()
+				return nil, false
+			}
+			l.mu.Lock()
+			l.spent[target] += time.Since(fetchStart)
+			l.completed[target] = true
+			log.Printf("abandoning %s: %v", target, err)
+			l.mu.Unlock()
+			return nil, false
+		}
+		if err == nil {
+			if l.dl.adaptiveThrottle != nil {
+				l.dl.adaptiveThrottle.Success()
+			}
+			break
+		} else if err == RateLimited {
+			if l.dl.adaptiveThrottle != nil {
+				l.dl.adaptiveThrottle.RateLimited()
+			}
+			backoff = nextRetryBackoff(backoff)
+			log.Printf("rate limit reached, retrying after %s", backoff.String())
+		} else {
+			backoff = nextRetryBackoff(backoff)
+			log.Printf("fetching failed: %v, retrying after %s", err, backoff.String())
+		}
+		if l.SubredditTimeout > 0 && spent+time.Since(fetchStart) > l.SubredditTimeout {
+			l.mu.Lock()
+			l.spent[target] += time.Since(fetchStart)
+			l.completed[target] = true
+			log.Printf("abandoning %s after spending %s fetching listings: %v", target, l.spent[target].String(), err)
+			l.mu.Unlock()
+			return nil, false
+		}
+		time.Sleep(backoff)
+		<-l.dl.throttler
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.spent[target] += time.Since(fetchStart)
+
+	if l.Rng != nil {
+		l.Rng.Shuffle(len(listing.Children), func(i, j int) {
+			listing.Children[i], listing.Children[j] = listing.Children[j], listing.Children[i]
+		})
+	}
+
+	for _, submission := range listing.Children {
+		// ignore meta submissions
+		if !submission.IsMeta {
+			submission.Target = target
+			children = append(children, submission)
+		}
+	}
+
+	if listing.After == "" {
+		l.completed[target] = true
+		log.Printf("completed %s", target)
+	} else if l.newestFirst(target, search) && l.dl.reachedMaxAge(listing.Children) {
+		// the listing is newest first, the following pages only have older submissions
+		l.completed[target] = true
+		log.Printf("completed %s, reached submissions older than %s", target, l.dl.MaxAge.String())
+	} else if len(listing.Children) == 0 || listing.After == after {
+		// reddit sometimes returns cursors that lead nowhere or back to the same page, following them would never end
+		l.completed[target] = true
+		log.Printf("stopping %s after a suspicious page (%d submissions, cursor %q => %q), the listing may be truncated", target, len(listing.Children), after, listing.After)
+	} else {
+		l.after[target] = listing.After
+	}
+	l.dl.countPage(target, len(children), l.completed[target])
+	return children, true
+}
+
+// fetchPages fetches the next page of every target, up to concurrency at a time and starting in the given order.
+// The submissions of each target are sent on the channel with the same index, nil if the target has no pages left.
+func (l *Lister) fetchPages(targets []string) []chan []Submission {
+	results := make([]chan []Submission, len(targets))
+	for i := range targets {
+		results[i] = make(chan []Submission, 1)
+	}
+	go func() {
+		running := make(chan struct{}, l.Concurrency)
+		for i, target := range targets {
+			page, ok := l.nextPage(target)
+			if !ok {
+				results[i] <- nil
+				continue
+			}
+			running <- struct{}{}
+			go func(target string, page int, result chan<- []Submission) {
+				defer func() { <-running }()
+				children, _ := l.fetchPage(target, page)
+				result <- children
+			}(target, page, results[i])
+		}
+	}()
+	return results
+}
+
+// RetryEmpty returns a Lister with the same settings for the targets that didn't produce a download,
+// or nil if every target did. Urls and hashes that weren't written are forgotten, so the retry doesn't skip
+// the images that failed before as duplicates. It must only be called once the submissions of l are handled.
+func (l *Lister) RetryEmpty() *Lister {
+	var empty []string
+	for _, target := range l.currentTargets() {
+		if l.dl.targetDownloads(target) == 0 {
+			empty = append(empty, target)
+		}
+	}
+	if len(empty) == 0 {
+		return nil
+	}
+	l.dl.forgetUnwritten()
+
+	retry := l.dl.NewLister(empty)
+	retry.PageSize = l.PageSize
+	retry.MaxPages = l.MaxPages
+	retry.Search = l.Search
+	retry.SubredditTimeout = l.SubredditTimeout
+	retry.FailFastOnAuth = l.FailFastOnAuth
+	retry.Concurrency = l.Concurrency
+	retry.Rng = l.Rng
+	return retry
+}
+
+// Run fetches page by page, round robin over the targets.
+func (l *Lister) Run(submissions chan<- Submission) {
+	for {
+		var pending []string
+		for _, target := range l.currentTargets() {
+			if !l.isCompleted(target) {
+				pending = append(pending, target)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		for _, result := range l.fetchPages(pending) {
+			for _, submission := range <-result {
+				submissions <- submission
+			}
+		}
+	}
+	close(submissions)
+}
+
+// RunMerged buffers a page of every target and always sends the newest buffered submission,
+// refilling a target's buffer once it is drained. This yields a newest-first order across all targets.
+func (l *Lister) RunMerged(submissions chan<- Submission) {
+	buffers := make(map[string][]Submission)
+	for {
+		targets := l.currentTargets()
+		// drop the buffers of removed targets
+		current := make(map[string]bool)
+		for _, target := range targets {
+			current[target] = true
+		}
+		for target := range buffers {
+			if !current[target] {
+				delete(buffers, target)
+			}
+		}
+
+		var refill []string
+		for _, target := range targets {
+			if len(buffers[target]) == 0 && !l.isCompleted(target) {
+				refill = append(refill, target)
+			}
+		}
+		for i, result := range l.fetchPages(refill) {
+			buffers[refill[i]] = <-result
+		}
+
+		newest := ""
+		for _, target := range targets {
+			if len(buffers[target]) > 0 && (newest == "" || buffers[target][0].CreatedUtc > buffers[newest][0].CreatedUtc) {
+				newest = target
+			}
+		}
+		if newest == "" {
+			// all buffers are empty, which is only the end if no target has pages left
+			allCompleted := true
+			for _, target := range targets {
+				if !l.isCompleted(target) {
+					allCompleted = false
+				}
+			}
+			if allCompleted {
+				break
+			}
+			continue
+		}
+
+		submissions <- buffers[newest][0]
+		buffers[newest] = buffers[newest][1:]
+	}
+	close(submissions)
+}
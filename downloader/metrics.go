@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const metricsPrefix = "reddit_image_downloader_"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes s in the Prometheus text exposition format
+func writeMetrics(w io.Writer, s Stats) {
+	family := func(name string, kind string, help string) {
+		_, _ = fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+	}
+	sample := func(name string, label string, value string, v interface{}) {
+		if label == "" {
+			_, _ = fmt.Fprintf(w, "%s%s %v\n", metricsPrefix, name, v)
+		} else {
+			_, _ = fmt.Fprintf(w, "%s%s{%s=\"%s\"} %v\n", metricsPrefix, name, label, labelEscaper.Replace(value), v)
+		}
+	}
+
+	family("submissions_total", "counter", "Submissions handled.")
+	sample("submissions_total", "", "", s.Submissions)
+	family("downloads_total", "counter", "Files written.")
+	sample("downloads_total", "", "", s.Downloaded)
+	family("downloaded_bytes_total", "counter", "Bytes of the files written.")
+	sample("downloaded_bytes_total", "", "", s.Bytes)
+	family("failures_total", "counter", "Failed downloads and writes.")
+	sample("failures_total", "", "", s.Failed)
+	family("skips_total", "counter", "Skipped submissions and images by reason.")
+	for _, reason := range sortedKeys(s.SkipReasons) {
+		sample("skips_total", "reason", reason, s.SkipReasons[reason])
+	}
+
+	// only listings queue submissions, the other sources send them one at a time
+	queued := s.Listed - s.Submissions
+	if queued < 0 {
+		queued = 0
+	}
+	family("queued_submissions", "gauge", "Submissions fetched from listings that weren't handled yet.")
+	sample("queued_submissions", "", "", queued)
+
+	targets := make([]string, 0, len(s.Targets))
+	for name := range s.Targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	family("target_pages_total", "counter", "Listing pages fetched per target.")
+	for _, name := range targets {
+		sample("target_pages_total", "target", name, s.Targets[name].Pages)
+	}
+	family("target_completed", "gauge", "Whether the listing of a target has no pages left.")
+	for _, name := range targets {
+		completed := 0
+		if s.Targets[name].Completed {
+			completed = 1
+		}
+		sample("target_completed", "target", name, completed)
+	}
+	family("target_downloads_total", "counter", "Files written per target.")
+	for _, name := range targets {
+		sample("target_downloads_total", "target", name, s.Targets[name].Downloaded)
+	}
+
+	subreddits := make([]string, 0, len(s.Subreddits))
+	for name := range s.Subreddits {
+		subreddits = append(subreddits, name)
+	}
+	sort.Strings(subreddits)
+	family("subreddit_submissions_total", "counter", "Submissions handled per subreddit they were posted to.")
+	for _, name := range subreddits {
+		sample("subreddit_submissions_total", "subreddit", name, s.Subreddits[name].Submissions)
+	}
+	family("subreddit_downloads_total", "counter", "Files written per subreddit.")
+	for _, name := range subreddits {
+		sample("subreddit_downloads_total", "subreddit", name, s.Subreddits[name].Downloaded)
+	}
+	family("subreddit_downloaded_bytes_total", "counter", "Bytes written per subreddit.")
+	for _, name := range subreddits {
+		sample("subreddit_downloaded_bytes_total", "subreddit", name, s.Subreddits[name].Bytes)
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StartMetricsServer serves the stats for Prometheus at /metrics on addr.
+// Nothing is collected beyond the stats, which are counted anyway.
+func (dl *Downloader) StartMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, dl.snapshotStats())
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("serving metrics on %s => %v", addr, err)
+		}
+	}()
+	log.Printf("serving metrics on %s/metrics", addr)
+	return server
+}
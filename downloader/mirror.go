@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mirrorFile copies p (and its raw metadata, if written) from the output root to the extra output roots.
+// Hardlinks are tried first. Failures are logged per root and don't stop the other roots.
+// Files written outside the output root by absolute templates are not mirrored, -archive mirrors the archives instead.
+func (dl *Downloader) mirrorFile(p string, u string, submission Submission) {
+	rel, ok := dl.mirrorPath(p)
+	if !ok {
+		return
+	}
+	files := []string{rel}
+	if dl.MetadataRaw {
+		if _, err := os.Stat(p + ".json"); err == nil {
+			files = append(files, rel+".json")
+		}
+	}
+	if dl.Checksums == "sidecar" {
+		if _, err := os.Stat(p + ".sha256"); err == nil {
+			files = append(files, rel+".sha256")
+		}
+	}
+	for _, root := range dl.ExtraOutputRoots {
+		for _, f := range files {
+			dst := filepath.Join(root, f)
+			err := dl.mirrorOne(filepath.Join(dl.OutputRoot, f), dst)
+			if err != nil {
+				dl.LogFailure("mirroring %s (%s) => %s: %v", u, submission.Permalink, dst, err)
+				break
+			}
+		}
+	}
+}
+
+// mirrorPath returns the path of p relative to the output root, if it is mirrored to the extra output roots
+func (dl *Downloader) mirrorPath(p string) (string, bool) {
+	if len(dl.ExtraOutputRoots) == 0 || dl.ArchiveFormat != "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dl.OutputRoot, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (dl *Downloader) mirrorOne(src string, dst string) error {
+	if !dl.Overwrite {
+		if _, err := os.Lstat(dst); err == nil {
+			return nil
+		}
+	} else {
+		_ = os.Remove(dst)
+	}
+	_ = os.MkdirAll(filepath.Dir(dst), os.ModeDir)
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	<-dl.writeThrottler
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	if dl.PreserveTime {
+		if info, err := os.Stat(src); err == nil {
+			_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+		}
+	}
+	return nil
+}
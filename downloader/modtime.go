@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// imgur's album api returns the upload time of images in this layout, in UTC
+const imgurDatetimeLayout = "2006-01-02 15:04:05"
+
+// postTime returns when submission was posted
+func postTime(submission Submission) time.Time {
+	return time.Unix(int64(submission.CreatedUtc), 0)
+}
+
+// albumImageTime returns when img was uploaded to imgur, or when submission was posted if that isn't known
+func albumImageTime(img AlbumImage, submission Submission) time.Time {
+	if t, err := time.Parse(imgurDatetimeLayout, img.Datetime); err == nil {
+		return t
+	}
+	return postTime(submission)
+}
+
+// setModTime sets the modification and access time of the written file p to t with PreserveTime.
+// Archive entries and stdout have no file of their own.
+func (dl *Downloader) setModTime(p string, t time.Time) {
+	if !dl.PreserveTime || dl.Stdout != nil || dl.ArchiveFormat != "" {
+		return
+	}
+	if err := os.Chtimes(p, t, t); err != nil {
+		log.Printf("setting the time of %s => %v", p, err)
+	}
+}
@@ -0,0 +1,125 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+)
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG from its header, or 0 if it has none.
+func jpegOrientation(header []byte) int {
+	tiff := jpegExif(header)
+	if tiff == nil {
+		return 0
+	}
+	return exifOrientation(tiff)
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the TIFF structure of an EXIF segment
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	entry, ok := exifEntry(tiff, order, order.Uint32(tiff[4:8]), 0x0112)
+	if !ok {
+		return 0
+	}
+	orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+	if orientation < 1 || orientation > 8 {
+		return 0
+	}
+	return orientation
+}
+
+// orientImage transforms img according to an EXIF orientation, so it is displayed correctly without it
+func orientImage(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// autoOrientDownload re-encodes a downloaded JPEG with an EXIF orientation other than "normal" in its display orientation.
+// Other images are left untouched. The re-encoded file has no EXIF data.
+func autoOrientDownload(d *download) (bool, error) {
+	orientation := jpegOrientation(d.header)
+	if orientation < 2 {
+		return false, nil
+	}
+	f, err := os.Open(d.file)
+	if err != nil {
+		return false, err
+	}
+	img, err := jpeg.Decode(f)
+	_ = f.Close()
+	if err != nil {
+		return false, err
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, orientImage(img, orientation), &jpeg.Options{Quality: 95})
+	if err != nil {
+		return false, err
+	}
+	f, err = os.OpenFile(d.file, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(buf.Bytes())
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	d.size = buf.Len()
+	sum := sha256.Sum256(buf.Bytes())
+	d.sum = string(sum[:])
+	d.header = buf.Bytes()
+	if len(d.header) > downloadHeaderSize {
+		d.header = d.header[:downloadHeaderSize]
+	}
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package downloader
+
+import (
+	"log"
+	"sync"
+)
+
+// pauser blocks new requests while paused, requests that are already running finish
+type pauser struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPauser() *pauser {
+	p := &pauser{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pauser) set(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == paused {
+		return
+	}
+	p.paused = paused
+	if paused {
+		log.Printf("pausing, running requests are finished")
+	} else {
+		log.Printf("resuming")
+		p.cond.Broadcast()
+	}
+}
+
+// wait returns once the run isn't paused
+func (p *pauser) wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.paused {
+		p.cond.Wait()
+	}
+}
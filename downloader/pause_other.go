@@ -0,0 +1,7 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package downloader
+
+// PauseOnSignal does nothing, there are no SIGUSR1 and SIGUSR2 on this platform
+func (dl *Downloader) PauseOnSignal() {}
@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package downloader
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// PauseOnSignal pauses the run on SIGUSR1 and resumes it on SIGUSR2
+func (dl *Downloader) PauseOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for s := range c {
+			dl.pause.set(s == syscall.SIGUSR1)
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"os"
+)
+
+// pixel hashes are prefixed so they can't collide with file hashes of undecodable images
+const pixelHashPrefix = "pixels:"
+
+// pixelHash returns the sha256 of the dimensions and RGBA pixels of the image in the file p.
+// ok is false for images that can't be decoded and for GIFs, whose first frame doesn't stand for the whole animation.
+func pixelHash(p string) (hash string, ok bool) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	img, imgType, err := image.Decode(bufio.NewReader(f))
+	if err != nil || imgType == "gif" {
+		return "", false
+	}
+	b := img.Bounds()
+	rgba, isRgba := img.(*image.RGBA)
+	if !isRgba || rgba.Rect.Min != (image.Point{}) || rgba.Stride != 4*b.Dx() {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	}
+
+	hasher := sha256.New()
+	var size [8]byte
+	binary.BigEndian.PutUint32(size[0:4], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(size[4:8], uint32(b.Dy()))
+	_, _ = hasher.Write(size[:])
+	_, _ = hasher.Write(rgba.Pix)
+	return pixelHashPrefix + string(hasher.Sum(nil)), true
+}
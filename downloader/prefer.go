@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ParsePrefer parses a -prefer list of extensions without dot, e.g. mp4,png,jpg. jpeg is the same as jpg.
+func ParsePrefer(list string) ([]string, error) {
+	var prefer []string
+	if list == "" {
+		return prefer, nil
+	}
+	for _, ext := range strings.Split(list, ",") {
+		ext = normalizePreferExt(ext)
+		if ext == "" || strings.ContainsAny(ext, "./") {
+			return nil, fmt.Errorf("invalid extension %q", ext)
+		}
+		prefer = append(prefer, ext)
+	}
+	return prefer, nil
+}
+
+func normalizePreferExt(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	if ext == "jpeg" {
+		return "jpg"
+	}
+	return ext
+}
+
+// preferRank returns the position of the extension of u in -prefer, or len(Prefer) if it isn't listed
+func (dl *Downloader) preferRank(u string) int {
+	ext := ""
+	if parsed, err := url.Parse(u); err == nil {
+		ext = normalizePreferExt(path.Ext(parsed.Path))
+	}
+	for i, p := range dl.Prefer {
+		if p == ext {
+			return i
+		}
+	}
+	return len(dl.Prefer)
+}
+
+// preferredOrder sorts candidate urls of the same image by -prefer, urls with unlisted extensions keep their order after the listed ones
+func (dl *Downloader) preferredOrder(candidates []string) []string {
+	sorted := append([]string(nil), candidates...)
+	if len(dl.Prefer) > 0 {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return dl.preferRank(sorted[i]) < dl.preferRank(sorted[j])
+		})
+	}
+	return sorted
+}
+
+// fetchFirst downloads the first of the candidate urls of the same image that exists, in the order of -prefer.
+// It returns errImageNotFound if none exists.
+func (dl *Downloader) fetchFirst(submission Submission, candidates []string, logFallback bool) error {
+	candidates = dl.preferredOrder(candidates)
+	for i, u := range candidates {
+		err := dl.tryFetchSingleImage(u, submission)
+		if err != errImageNotFound {
+			return err
+		}
+		if logFallback && i+1 < len(candidates) {
+			log.Printf("fetching %s (%s) => not found, falling back to %s", u, submission.Permalink, candidates[i+1])
+		}
+	}
+	return errImageNotFound
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"sort"
+	"strings"
+)
+
+// resolution buckets by the longer side
+var probeBuckets = []struct {
+	max  int
+	name string
+}{
+	{640, "< 640px"},
+	{1280, "640-1279px"},
+	{1920, "1280-1919px"},
+	{2560, "1920-2559px"},
+	{3840, "2560-3839px"},
+}
+
+// recordProbe tallies type, orientation and resolution of a downloaded image for -probe.
+func (dl *Downloader) recordProbe(data []byte) {
+	cfg, imgType, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		dl.probeTypes["undecodable"]++
+		return
+	}
+	dl.probeTypes[imgType]++
+
+	dl.probeOrientations[dl.orientation(cfg.Width, cfg.Height)]++
+
+	side := cfg.Width
+	if cfg.Height > side {
+		side = cfg.Height
+	}
+	bucket := ">= 3840px"
+	for _, b := range probeBuckets {
+		if side < b.max {
+			bucket = b.name
+			break
+		}
+	}
+	dl.probeResolutions[bucket]++
+}
+
+func (dl *Downloader) printProbe() {
+	printHistogram("type", dl.probeTypes, nil)
+	printHistogram("orientation", dl.probeOrientations, nil)
+	order := make([]string, 0, len(probeBuckets)+1)
+	for _, b := range probeBuckets {
+		order = append(order, b.name)
+	}
+	order = append(order, ">= 3840px")
+	printHistogram("resolution", dl.probeResolutions, order)
+}
+
+// printHistogram logs counts as bars, in the given order or by descending count if order is nil
+func printHistogram(title string, counts map[string]int, order []string) {
+	total := 0
+	max := 0
+	for _, c := range counts {
+		total += c
+		if c > max {
+			max = c
+		}
+	}
+	if order == nil {
+		for k := range counts {
+			order = append(order, k)
+		}
+		sort.Slice(order, func(i, j int) bool {
+			if counts[order[i]] != counts[order[j]] {
+				return counts[order[i]] > counts[order[j]]
+			}
+			return order[i] < order[j]
+		})
+	}
+	log.Printf("%s (%d images):", title, total)
+	// nothing was tallied, e.g. all images were filtered out, the buckets would only show 0 of 0
+	if total == 0 {
+		return
+	}
+	for _, k := range order {
+		c := counts[k]
+		bar := 0
+		if max > 0 {
+			bar = c * 40 / max
+		}
+		log.Printf("  %-12s %6d %5.1f%% %s", k, c, 100*float64(c)/float64(total), strings.Repeat("#", bar))
+	}
+}
@@ -0,0 +1,27 @@
+package downloader
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPrintHistogram(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	printHistogram("resolution", map[string]int{}, []string{"< 640px", ">= 3840px"})
+	if strings.Contains(logged.String(), "NaN") || strings.Contains(logged.String(), "640px") {
+		t.Errorf("logged %q for an empty histogram, want only the title", logged.String())
+	}
+
+	logged.Reset()
+	printHistogram("type", map[string]int{"jpeg": 3, "png": 1}, nil)
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "type (4 images):") || !strings.Contains(lines[1], "jpeg") || !strings.Contains(lines[1], "75.0%") || !strings.Contains(lines[2], "25.0%") {
+		t.Errorf("logged %q", logged.String())
+	}
+}
@@ -0,0 +1,172 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress redraws the counters of the stats at the bottom of a terminal for -tui.
+// Log lines are written above it, so errors stay visible.
+type progress struct {
+	dl       *Downloader
+	out      *os.File
+	maxPages int
+
+	mu sync.Mutex
+	// lines of the last drawing, which are erased before writing log lines or drawing again
+	lines int
+}
+
+// isTerminal reports whether f is a character device, e.g. not a file or a pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// StartProgress draws the listing progress per target and the totals in place on stderr and takes over the log output,
+// until the returned function is called. maxPages is the page limit of the targets (0 = none) for their bars.
+// It returns false and does nothing if stderr isn't a terminal.
+func (dl *Downloader) StartProgress(maxPages int) (stop func(), ok bool) {
+	if !isTerminal(os.Stderr) {
+		return func() {}, false
+	}
+	p := &progress{dl: dl, out: os.Stderr, maxPages: maxPages}
+	log.SetOutput(p)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			p.draw()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		p.draw()
+		log.SetOutput(os.Stderr)
+	}, true
+}
+
+// Write writes a log line above the progress
+func (p *progress) Write(line []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+	n, err := p.out.Write(line)
+	p.render()
+	return n, err
+}
+
+func (p *progress) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.erase()
+	p.render()
+}
+
+// erase moves the cursor to the start of the last drawing and clears everything below
+func (p *progress) erase() {
+	if p.lines > 0 {
+		_, _ = fmt.Fprintf(p.out, "\x1b[%dA\r\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// render draws the progress below the cursor. Every line is cut to the terminal width, so none wraps and erase
+// knows how many rows to go up, and targets that don't fit on the screen are summarized in one line.
+func (p *progress) render() {
+	columns, rows, ok := terminalSize(p.out)
+	if !ok {
+		columns, rows = 80, 24
+	}
+	s := p.dl.snapshotStats()
+	names := make([]string, 0, len(s.Targets))
+	for name := range s.Targets {
+		names = append(names, name)
+	}
+	// the running targets first, they are the interesting ones
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := s.Targets[names[i]].Completed, s.Targets[names[j]].Completed
+		if ci != cj {
+			return cj
+		}
+		return names[i] < names[j]
+	})
+	// leave a row for the totals and one for the log lines above
+	maxTargets := rows - 2
+	if maxTargets < 1 {
+		maxTargets = 1
+	}
+	hidden := 0
+	if len(names) > maxTargets {
+		hidden = len(names) - (maxTargets - 1)
+		names = names[:maxTargets-1]
+	}
+
+	var lines []string
+	for _, name := range names {
+		t := s.Targets[name]
+		if len(name) > 30 {
+			name = name[:29] + "…"
+		}
+		pages := fmt.Sprintf("%d pages", t.Pages)
+		if p.maxPages > 0 {
+			pages = fmt.Sprintf("%s %d/%d pages", progressBar(t.Pages, p.maxPages, t.Completed), t.Pages, p.maxPages)
+		}
+		state := ""
+		if t.Completed {
+			state = "  completed"
+		}
+		lines = append(lines, fmt.Sprintf("%-30s  %s  %d downloaded%s", name, pages, t.Downloaded, state))
+	}
+	if hidden > 0 {
+		lines = append(lines, fmt.Sprintf("… and %d more targets", hidden))
+	}
+	lines = append(lines, fmt.Sprintf("running for %s: %d submissions, %d downloaded (%d bytes), %d skipped, %d failed",
+		time.Since(s.Started).Round(time.Second).String(), s.Submissions, s.Downloaded, s.Bytes, s.Skipped, s.Failed))
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		// one column less, some terminals wrap when the last column is written
+		buf.WriteString(truncateColumns(line, columns-1))
+		buf.WriteByte('\n')
+	}
+	p.lines = len(lines)
+	_, _ = p.out.Write(buf.Bytes())
+}
+
+// truncateColumns cuts line to at most n characters, which are all one column wide in the progress
+func truncateColumns(line string, n int) string {
+	if n < 1 {
+		n = 1
+	}
+	runes := []rune(line)
+	if len(runes) <= n {
+		return line
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// progressBar renders n of max as a bar of 20 characters, full once completed
+func progressBar(n int, max int, completed bool) string {
+	const width = 20
+	filled := width
+	if !completed && n < max {
+		filled = width * n / max
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
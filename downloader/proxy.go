@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// a proxy is dropped for proxyCooldown after this many failed requests in a row
+const proxyMaxFailures = 3
+const proxyCooldown = 5 * time.Minute
+
+// proxyPool is a http.RoundTripper that distributes requests over the proxies of -proxy-list,
+// each with its own transport. Proxies that keep failing are temporarily dropped.
+type proxyPool struct {
+	random bool
+
+	mu      sync.Mutex
+	proxies []*poolProxy
+	next    int
+}
+
+type poolProxy struct {
+	url       *url.URL
+	transport *http.Transport
+	// failures in a row
+	failures      int
+	requests      int
+	totalFailures int
+	disabledUntil time.Time
+}
+
+func NewProxyPool(proxyUrls []string, random bool) (*proxyPool, error) {
+	if len(proxyUrls) == 0 {
+		return nil, fmt.Errorf("no proxies")
+	}
+	pool := &proxyPool{random: random}
+	for _, p := range proxyUrls {
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url: %s", p)
+		}
+		pool.proxies = append(pool.proxies, &poolProxy{
+			url:       u,
+			transport: &http.Transport{Proxy: http.ProxyURL(u)},
+		})
+	}
+	return pool, nil
+}
+
+// pick returns the next proxy that isn't dropped, or the one that comes back first if all are dropped
+func (p *proxyPool) pick() *poolProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var available []*poolProxy
+	for _, proxy := range p.proxies {
+		if !now.Before(proxy.disabledUntil) {
+			available = append(available, proxy)
+		}
+	}
+	if len(available) == 0 {
+		first := p.proxies[0]
+		for _, proxy := range p.proxies {
+			if proxy.disabledUntil.Before(first.disabledUntil) {
+				first = proxy
+			}
+		}
+		return first
+	}
+	if p.random {
+		return available[rand.Intn(len(available))]
+	}
+	p.next = (p.next + 1) % len(available)
+	return available[p.next]
+}
+
+func (p *proxyPool) record(proxy *poolProxy, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.requests++
+	if !failed {
+		proxy.failures = 0
+		return
+	}
+	proxy.failures++
+	proxy.totalFailures++
+	if proxy.failures >= proxyMaxFailures {
+		proxy.failures = 0
+		proxy.disabledUntil = time.Now().Add(proxyCooldown)
+		log.Printf("proxy %s failed %d times in a row (%d of %d requests failed), dropping it for %s",
+			proxy.url.Host, proxyMaxFailures, proxy.totalFailures, proxy.requests, proxyCooldown.String())
+	}
+}
+
+func (p *proxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy := p.pick()
+	resp, err := proxy.transport.RoundTrip(req)
+	// errors and blocks (forbidden, rate limited, bad gateway) count against the proxy, 404s and our own timeouts don't
+	var failed bool
+	if err != nil {
+		failed = req.Context().Err() == nil
+	} else {
+		failed = resp.StatusCode == 403 || resp.StatusCode == 429 || resp.StatusCode >= 500
+	}
+	p.record(proxy, failed)
+	return resp, err
+}
@@ -0,0 +1,448 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var RateLimited error = errors.New("rate limited")
+
+// AuthError is returned for 401 and 403 responses, which retrying doesn't fix.
+// Reason is reddit's explanation for subreddits that can't be accessed (e.g. private or quarantined),
+// it is empty if the request itself was denied.
+type AuthError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e *AuthError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("access denied (%d %s)", e.StatusCode, e.Reason)
+	}
+	return fmt.Sprintf("authentication failed (%d %s)", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+const defaultRedditBaseUrl = "https://www.reddit.com"
+
+type RedditClient struct {
+	http *http.Client
+	// baseUrl defaults to https://www.reddit.com, tests can point it at a local server
+	baseUrl string
+	// cookies are only added to reddit requests, unlike a jar of the shared http client
+	cookies http.CookieJar
+}
+
+func (r RedditClient) base() string {
+	if r.baseUrl == "" {
+		return defaultRedditBaseUrl
+	}
+	return r.baseUrl
+}
+
+func encodeNewListingParams(params NewListingParams) string {
+	q := url.Values{}
+	q.Add("raw_json", "1")
+	q.Add("sr_detail", "1")
+	if params.Limit > 0 {
+		q.Add("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Before != "" {
+		q.Add("before", params.Before)
+	}
+	if params.After != "" {
+		q.Add("after", params.After)
+	}
+	if params.Time != "" {
+		q.Add("t", params.Time)
+	}
+	if params.Geo != "" {
+		q.Add("geo_filter", params.Geo)
+	}
+	return q.Encode()
+}
+
+func encodeSearchListingParams(params SearchListingParams) string {
+	q := url.Values{}
+	q.Add("raw_json", "1")
+	q.Add("sr_detail", "1")
+	q.Add("restrict_sr", "on")
+	if params.Sort != "" {
+		q.Add("sort", params.Sort)
+	} else {
+		q.Add("sort", "new")
+	}
+	if params.Time != "" {
+		q.Add("t", params.Time)
+	}
+	if params.Limit > 0 {
+		q.Add("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Before != "" {
+		q.Add("before", params.Before)
+	}
+	if params.After != "" {
+		q.Add("after", params.After)
+	}
+	if params.Search != "" {
+		q.Add("q", params.Search)
+	}
+	if params.Geo != "" {
+		q.Add("geo_filter", params.Geo)
+	}
+
+	return q.Encode()
+}
+
+func (r RedditClient) GetSearch(subreddit string, params SearchListingParams) (Listing, error) {
+	urlParams := encodeSearchListingParams(params)
+	u := fmt.Sprintf(`%s/r/%s/search.json?%s`, r.base(), subreddit, urlParams)
+	var listing Listing
+	err := r.getJSON(u, &listing)
+	return listing, err
+}
+
+func (r RedditClient) GetNew(subreddit string, params NewListingParams) (Listing, error) {
+	urlParams := encodeNewListingParams(params)
+	u := fmt.Sprintf(`%s/r/%s/new.json?%s`, r.base(), subreddit, urlParams)
+	var listing Listing
+	err := r.getJSON(u, &listing)
+	return listing, err
+}
+
+// GetDomain fetches the submissions linking to a domain across all subreddits, the newest ones unless params.Sort is set.
+func (r RedditClient) GetDomain(domain string, params NewListingParams) (Listing, error) {
+	sort := params.Sort
+	if sort == "" {
+		sort = "new"
+	}
+	urlParams := encodeNewListingParams(params)
+	u := fmt.Sprintf(`%s/domain/%s/%s.json?%s`, r.base(), domain, sort, urlParams)
+	var listing Listing
+	err := r.getJSON(u, &listing)
+	return listing, err
+}
+
+// GetUser fetches the newest submissions of a user.
+func (r RedditClient) GetUser(user string, params NewListingParams) (Listing, error) {
+	urlParams := encodeNewListingParams(params)
+	u := fmt.Sprintf(`%s/user/%s/submitted.json?%s`, r.base(), user, urlParams)
+	var listing Listing
+	err := r.getJSON(u, &listing)
+	return listing, err
+}
+
+// GetMulti fetches the newest submissions of a user's multireddit.
+func (r RedditClient) GetMulti(user string, multi string, params NewListingParams) (Listing, error) {
+	urlParams := encodeNewListingParams(params)
+	u := fmt.Sprintf(`%s/user/%s/m/%s/new.json?%s`, r.base(), user, multi, urlParams)
+	var listing Listing
+	err := r.getJSON(u, &listing)
+	return listing, err
+}
+
+// GetPost fetches a single submission by its permalink (e.g. /r/pics/comments/abc123/title/).
+func (r RedditClient) GetPost(permalink string) (Submission, error) {
+	u := fmt.Sprintf(`%s%s.json?raw_json=1&sr_detail=1`, r.base(), strings.TrimSuffix(permalink, "/"))
+	// the response is the post listing followed by the comment listing
+	var listings []Listing
+	err := r.getJSON(u, &listings)
+	if err != nil {
+		return Submission{}, err
+	}
+	if len(listings) == 0 || len(listings[0].Children) == 0 {
+		return Submission{}, fmt.Errorf("no submission found at %s", permalink)
+	}
+	return listings[0].Children[0], nil
+}
+
+// Comment is a reddit comment, uninteresting members are omitted
+type Comment struct {
+	Author string
+	Body   string
+	// IsSubmitter is true for comments of the submission's author
+	IsSubmitter bool `json:"is_submitter"`
+}
+
+// GetTopLevelComments fetches the top-level comments of a submission by its permalink, best first.
+func (r RedditClient) GetTopLevelComments(permalink string) ([]Comment, error) {
+	u := fmt.Sprintf(`%s%s.json?raw_json=1&depth=1&sort=top&limit=100`, r.base(), strings.TrimSuffix(permalink, "/"))
+	// the response is the post listing followed by the comment listing, which ends with a "more" entry (kind t1 are comments)
+	var listings []struct {
+		Data struct {
+			Children []struct {
+				Kind string
+				Data Comment
+			}
+		}
+	}
+	err := r.getJSON(u, &listings)
+	if err != nil {
+		return nil, err
+	}
+	if len(listings) < 2 {
+		return nil, fmt.Errorf("no comments found at %s", permalink)
+	}
+	var comments []Comment
+	for _, child := range listings[1].Data.Children {
+		if child.Kind == "t1" {
+			comments = append(comments, child.Data)
+		}
+	}
+	return comments, nil
+}
+
+// QuarantineOptIn accepts the quarantine of a subreddit for the account of the session cookie (see -cookie-file),
+// reddit denies listing a quarantined subreddit until then.
+func (r RedditClient) QuarantineOptIn(subreddit string) error {
+	if r.cookies == nil {
+		return errors.New("not logged in, see -cookie-file")
+	}
+	var me struct {
+		Data struct {
+			Modhash string
+		}
+	}
+	err := r.getJSON(r.base()+"/api/me.json", &me)
+	if err != nil {
+		return err
+	}
+	if me.Data.Modhash == "" {
+		return errors.New("not logged in, the session cookie may have expired")
+	}
+
+	form := url.Values{}
+	form.Add("sr_name", subreddit)
+	form.Add("accept", "true")
+	form.Add("uh", me.Data.Modhash)
+	req, err := r.newRequest("POST", r.base()+"/api/quarantine_optin", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Modhash", me.Data.Modhash)
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("opt-in failed (%d %s)", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// newRequest returns a request with the headers and cookies of every reddit request
+func (r RedditClient) newRequest(method string, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "reddit image downloader")
+	if r.cookies != nil {
+		for _, cookie := range r.cookies.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+	return req, nil
+}
+
+func (r RedditClient) getJSON(u string, v interface{}) error {
+	req, err := r.newRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("error closing response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode == 429 {
+		return RateLimited
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		var denied struct {
+			Reason string
+		}
+		_ = json.Unmarshal(body, &denied)
+		return &AuthError{StatusCode: resp.StatusCode, Reason: denied.Reason}
+	}
+	return json.Unmarshal(body, v)
+}
+
+type NewListingParams struct {
+	Limit  int
+	Before string
+	After  string
+	// Sort and Time only apply to GetDomain, Sort defaults to new
+	Sort string
+	Time string
+	// Geo is the geo_filter, a country code like US or GLOBAL
+	Geo string
+}
+
+type SearchListingParams struct {
+	Limit  int
+	Before string
+	After  string
+	Search string
+	// Sort defaults to new
+	Sort string
+	// Time is the time window of the top, relevance and comments sorts (hour, day, week, month, year or all)
+	Time string
+	// Geo is the geo_filter, a country code like US or GLOBAL
+	Geo string
+}
+
+type Listing struct {
+	Kind        string
+	ListingData `json:"data"`
+}
+
+type ListingData struct {
+	Modhash  string
+	Dist     int
+	Children []Submission
+	After    string
+	Before   string
+}
+
+type Submission struct {
+	Kind           string
+	SubmissionData `json:"data"`
+	// RawData is the unmodified json of the data object, including all omitted members
+	RawData json.RawMessage `json:"-"`
+	// Target is the target whose listing contained the submission, "" if it didn't come from a listing
+	Target string `json:"-"`
+}
+
+func (s *Submission) UnmarshalJSON(data []byte) error {
+	// plain has the same fields but not this method, which avoids infinite recursion
+	type plain Submission
+	var p plain
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return err
+	}
+	var raw struct {
+		Data json.RawMessage
+	}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	*s = Submission(p)
+	s.RawData = raw.Data
+	s.IsCrosspost = s.CrosspostParent != "" || len(s.CrosspostParentList) > 0
+	return nil
+}
+
+type SubmissionData struct {
+	// uninteresting members are omitted
+	Title      string
+	Name       string
+	Id         string
+	IsMeta     bool   `json:"is_meta"`
+	PostHint   string `json:"post_hint"`
+	Domain     string
+	Author     string
+	CreatedUtc float64 `json:"created_utc"`
+	Url        string
+	Permalink  string
+	Subreddit  string
+	IsSelf     bool   `json:"is_self"`
+	Selftext   string `json:"selftext"`
+	Nsfw       bool   `json:"over_18"`
+	Spoiler    bool   `json:"spoiler"`
+	Score      int    `json:"score"`
+	// LinkFlairText is the flair shown next to the title
+	LinkFlairText     string `json:"link_flair_text"`
+	IsOriginalContent bool   `json:"is_original_content"`
+	Media             *Media `json:"media"`
+	SecureMedia       *Media `json:"secure_media"`
+	// CrosspostParent is the name of the original submission of a crosspost
+	CrosspostParent string `json:"crosspost_parent"`
+	// CrosspostParentList holds the original submission of a crosspost
+	CrosspostParentList []SubmissionData `json:"crosspost_parent_list"`
+	// IsCrosspost is set when decoding a Submission if either of the above is present
+	IsCrosspost bool `json:"-"`
+	// Preview holds reddit's resized copies of the image
+	Preview *Preview `json:"preview"`
+	// IsGallery is set for reddit galleries, which can have an "image" PostHint when posted by older clients
+	IsGallery     bool                     `json:"is_gallery"`
+	GalleryData   *GalleryData             `json:"gallery_data"`
+	MediaMetadata map[string]MediaMetadata `json:"media_metadata"`
+	// SrDetail describes the subreddit, it is empty for sources other than the reddit api
+	SrDetail SrDetail `json:"sr_detail"`
+}
+
+// SrDetail is the subset of the subreddit data that reddit includes with sr_detail=1
+type SrDetail struct {
+	DisplayName   string `json:"display_name"`
+	Title         string
+	Over18        bool   `json:"over18"`
+	SubredditType string `json:"subreddit_type"`
+	Subscribers   int
+}
+
+type Preview struct {
+	Images  []PreviewImage
+	Enabled bool
+}
+
+type PreviewImage struct {
+	Id          string
+	Source      PreviewSource
+	Resolutions []PreviewSource
+	// Variants are other versions of the image, e.g. obscured (blurred for nsfw and spoilers), gif and mp4
+	Variants map[string]PreviewVariant
+}
+
+type PreviewVariant struct {
+	Source      PreviewSource
+	Resolutions []PreviewSource
+}
+
+type PreviewSource struct {
+	Url    string
+	Width  int
+	Height int
+}
+
+// Media describes embedded media from external providers
+type Media struct {
+	Type   string
+	Oembed Oembed
+}
+
+type Oembed struct {
+	ProviderName    string `json:"provider_name"`
+	Title           string
+	ThumbnailUrl    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+}
@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// listingJson returns a listing page with a submission per id and the after cursor
+func listingJson(after string, ids ...string) string {
+	children := make([]string, len(ids))
+	for i, id := range ids {
+		children[i] = fmt.Sprintf(`{"kind":"t3","data":{"id":%q,"name":"t3_%s","url":"https://i.redd.it/%s.jpg","post_hint":"image","subreddit":"pics"}}`, id, id, id)
+	}
+	return fmt.Sprintf(`{"kind":"Listing","data":{"after":%q,"children":[%s]}}`, after, strings.Join(children, ","))
+}
+
+// listAll runs l and returns the ids of all submissions in the order they were sent
+func listAll(l *Lister) []string {
+	submissions := make(chan Submission)
+	go l.Run(submissions)
+	var ids []string
+	for submission := range submissions {
+		ids = append(ids, submission.Id)
+	}
+	return ids
+}
+
+func TestGetNewPassesParams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/r/pics/new.json" {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("after") != "t3_x" || q.Get("limit") != "25" || q.Get("raw_json") != "1" {
+			t.Errorf("query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(listingJson("t3_b", "a", "b")))
+	}))
+	defer server.Close()
+
+	client := RedditClient{http: http.DefaultClient, baseUrl: server.URL}
+	listing, err := client.GetNew("pics", NewListingParams{Limit: 25, After: "t3_x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Children) != 2 || listing.Children[0].Id != "a" || listing.After != "t3_b" {
+		t.Errorf("decoded %+v", listing.ListingData)
+	}
+	if string(listing.Children[1].RawData) == "" {
+		t.Error("raw data of the submission missing")
+	}
+}
+
+func TestGetDomainPassesSortAndTime(t *testing.T) {
+	for _, test := range []struct {
+		params NewListingParams
+		path   string
+		time   string
+	}{
+		{params: NewListingParams{}, path: "/domain/imgur.com/new.json"},
+		{params: NewListingParams{Sort: "top", Time: "week"}, path: "/domain/imgur.com/top.json", time: "week"},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != test.path || r.URL.Query().Get("t") != test.time || r.URL.Query().Get("after") != "t3_x" {
+				t.Errorf("requested %s, want %s with t=%s", r.URL.String(), test.path, test.time)
+			}
+			_, _ = w.Write([]byte(listingJson("", "a")))
+		}))
+
+		client := RedditClient{http: http.DefaultClient, baseUrl: server.URL}
+		test.params.After = "t3_x"
+		if _, err := client.GetDomain("imgur.com", test.params); err != nil {
+			t.Error(err)
+		}
+		server.Close()
+	}
+}
+
+func TestGetNewRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := RedditClient{http: http.DefaultClient, baseUrl: server.URL}
+	_, err := client.GetNew("pics", NewListingParams{})
+	if err != RateLimited {
+		t.Errorf("error %v, want RateLimited", err)
+	}
+}
+
+func TestGetNewAuthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"reason":"private","message":"Forbidden","error":403}`))
+	}))
+	defer server.Close()
+
+	client := RedditClient{http: http.DefaultClient, baseUrl: server.URL}
+	_, err := client.GetNew("pics", NewListingParams{})
+	authErr, ok := err.(*AuthError)
+	if !ok || authErr.StatusCode != 403 || authErr.Reason != "private" {
+		t.Errorf("error %#v, want an AuthError with reason private", err)
+	}
+}
+
+func TestListerFollowsPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch after := r.URL.Query().Get("after"); after {
+		case "":
+			_, _ = w.Write([]byte(listingJson("t3_b", "a", "b")))
+		case "t3_b":
+			_, _ = w.Write([]byte(listingJson("t3_d", "c", "d")))
+		case "t3_d":
+			_, _ = w.Write([]byte(listingJson("", "e")))
+		default:
+			t.Errorf("unexpected cursor %s", after)
+		}
+	}))
+	defer server.Close()
+
+	dl := New()
+	dl.redditClient.baseUrl = server.URL
+	l := dl.NewLister([]string{"pics"})
+	l.PageSize = 2
+
+	ids := listAll(l)
+	if strings.Join(ids, ",") != "a,b,c,d,e" {
+		t.Errorf("listed %v", ids)
+	}
+	stats := dl.snapshotStats().Targets["r/pics"]
+	if stats.Pages != 3 || !stats.Completed {
+		t.Errorf("target stats %+v, want 3 pages and completed", stats)
+	}
+}
+
+func TestListerStopsAtMaxPages(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		_, _ = w.Write([]byte(listingJson(fmt.Sprintf("t3_%d", n), fmt.Sprint(n))))
+	}))
+	defer server.Close()
+
+	dl := New()
+	dl.redditClient.baseUrl = server.URL
+	l := dl.NewLister([]string{"pics"})
+	l.MaxPages = 2
+
+	ids := listAll(l)
+	if len(ids) != 2 || requests != 2 {
+		t.Errorf("listed %v with %d requests, want 2 pages", ids, requests)
+	}
+}
+
+func TestListerRetriesRateLimited(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(listingJson("", "a")))
+	}))
+	defer server.Close()
+
+	dl := New()
+	dl.redditClient.baseUrl = server.URL
+	l := dl.NewLister([]string{"pics"})
+
+	ids := listAll(l)
+	if len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("listed %v after the rate limit, want [a]", ids)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want the rate limited one and its retry", requests)
+	}
+}
+
+func TestSetTargetConfigsReplacesSettings(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path+"?q="+r.URL.Query().Get("q"))
+		mu.Unlock()
+		_, _ = w.Write([]byte(listingJson("")))
+	}))
+	defer server.Close()
+
+	dl := New()
+	dl.redditClient.baseUrl = server.URL
+	dl.TargetConfigs["pics"] = &TargetConfig{Target: "pics", Search: "old"}
+	l := dl.NewLister([]string{"pics"})
+	l.SetTargetConfigs([]string{"pics", "aww"}, map[string]*TargetConfig{"aww": {Target: "aww", Search: "new"}})
+
+	listAll(l)
+	sort.Strings(paths)
+	if strings.Join(paths, ",") != "/r/aww/search.json?q=new,/r/pics/new.json?q=" {
+		t.Errorf("requested %v, want the search of the new settings only", paths)
+	}
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GalleryData is the order and captions of the images of a reddit gallery, the images are in the media metadata
+type GalleryData struct {
+	Items []GalleryDataItem
+}
+
+type GalleryDataItem struct {
+	MediaId string `json:"media_id"`
+	Caption string
+}
+
+type MediaMetadata struct {
+	// Status is "valid" for images that can be downloaded
+	Status string
+	// E is the kind of media, Image or AnimatedImage
+	E string
+	// M is the mime type, e.g. image/jpg
+	M string
+	S MediaSource
+}
+
+type MediaSource struct {
+	X   int
+	Y   int
+	U   string
+	Gif string
+	Mp4 string
+}
+
+// redditGalleryImages turns the images of a reddit gallery into album images, in gallery order
+func redditGalleryImages(submission Submission) []AlbumImage {
+	var images []AlbumImage
+	if submission.GalleryData == nil {
+		return images
+	}
+	for _, item := range submission.GalleryData.Items {
+		meta, ok := submission.MediaMetadata[item.MediaId]
+		if !ok || meta.Status != "valid" {
+			continue
+		}
+		ext := "." + strings.TrimPrefix(meta.M, "image/")
+		if ext == ".jpeg" {
+			ext = ".jpg"
+		}
+		img := AlbumImage{
+			Hash:     item.MediaId,
+			Title:    item.Caption,
+			Ext:      ext,
+			Animated: meta.E == "AnimatedImage",
+			Mp4:      meta.S.Mp4,
+			Url:      fmt.Sprintf("https://i.redd.it/%s%s", item.MediaId, ext),
+		}
+		images = append(images, img)
+	}
+	return images
+}
+
+// fetchRedditGallery downloads the images of a reddit gallery like an imgur album
+func (dl *Downloader) fetchRedditGallery(submission Submission) error {
+	if dl.NoAlbums {
+		dl.logSkip(submission, submission.Url, "albums disabled", "skipping reddit gallery: %s\n", submission.Url)
+		return nil
+	}
+	if dl.SkipDuplicates {
+		if _, exists := dl.knownUrls[submission.Url]; exists {
+			dl.logSkip(submission, submission.Url, "duplicate", "skipping reddit gallery: %s\n", submission.Url)
+			return nil
+		}
+		dl.knownUrls[submission.Url] = ""
+	}
+	images := redditGalleryImages(submission)
+	if len(images) == 0 {
+		dl.logFetchFailure(submission, submission.Url, "no images", "fetching reddit gallery %s (%s) => no images", submission.Url, submission.Permalink)
+		return errImageNotFound
+	}
+	count := len(images)
+	if submission.GalleryData != nil && len(submission.GalleryData.Items) > count {
+		count = len(submission.GalleryData.Items)
+	}
+	return dl.fetchAlbum(submission, images, count)
+}
@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ReloadOnSignal re-reads the -config file and the -subreddits-file on SIGHUP and replaces the targets of l
+// with args and the targets of both files. Files given as "" are skipped.
+// An unreadable or invalid file keeps the current targets and settings.
+func ReloadOnSignal(l *Lister, args []string, configFile string, subredditsFile string) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			targets := args
+			var configs map[string]*TargetConfig
+			if configFile != "" {
+				var configured []string
+				var err error
+				configs, configured, err = ReadConfigFile(configFile)
+				if err != nil {
+					log.Printf("reloading %s => %v, keeping the current subreddits", configFile, err)
+					continue
+				}
+				targets = MergeTargets(targets, configured)
+			}
+			if subredditsFile != "" {
+				fromFile, err := ReadTargetsFile(subredditsFile)
+				if err != nil {
+					log.Printf("reloading %s => %v, keeping the current subreddits", subredditsFile, err)
+					continue
+				}
+				targets = MergeTargets(targets, fromFile)
+			}
+			if configFile != "" {
+				l.SetTargetConfigs(targets, configs)
+				log.Printf("reloading %s => %d settings", configFile, len(configs))
+			} else {
+				l.SetTargets(targets)
+			}
+			log.Printf("reloading => %d subreddits", len(targets))
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// reserved device names of Windows, which can't be used as file names even with an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizePath makes every segment of a rendered path a valid file name on Windows, macOS and Linux for -sanitize-paths:
+// control characters and <>:"|?* (and \ where it isn't a separator) are replaced with _, trailing dots and spaces
+// are removed and reserved names like CON get a _ prefix. The volume name of absolute Windows paths is kept.
+func sanitizePath(p string) string {
+	volume := filepath.VolumeName(p)
+	segments := strings.FieldsFunc(p[len(volume):], func(r rune) bool {
+		return r == '/' || r == os.PathSeparator
+	})
+	for i, segment := range segments {
+		segments[i] = sanitizeSegment(segment)
+	}
+	sanitized := strings.Join(segments, "/")
+	if strings.HasPrefix(p[len(volume):], "/") || strings.HasPrefix(p[len(volume):], string(os.PathSeparator)) {
+		sanitized = "/" + sanitized
+	}
+	return volume + filepath.FromSlash(sanitized)
+}
+
+func sanitizeSegment(segment string) string {
+	if segment == "." || segment == ".." {
+		return segment
+	}
+	segment = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*\`, r) {
+			return '_'
+		}
+		return r
+	}, segment)
+	segment = strings.TrimRight(segment, ". ")
+	if segment == "" {
+		return "_"
+	}
+	name := segment
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimSpace(name))] {
+		segment = "_" + segment
+	}
+	return segment
+}
@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// DefaultScreenshotRules skips tiny images, extreme aspect ratios (e.g. scrolling screenshots) and
+// PNGs with the exact screen resolution of common phones
+const DefaultScreenshotRules = "min-side=320,max-ratio=3," +
+	"png=720x1280,png=750x1334,png=828x1792,png=1080x1920,png=1080x2340,png=1080x2400,png=1125x2436," +
+	"png=1170x2532,png=1179x2556,png=1242x2208,png=1242x2688,png=1284x2778,png=1290x2796,png=1440x3200"
+
+// ScreenshotRules are the parsed -screenshot-rules
+type ScreenshotRules struct {
+	minSide  int
+	maxRatio float64
+	// resolutions per image type ("*" for all types), in portrait orientation
+	resolutions map[string]map[[2]int]bool
+}
+
+// ParseScreenshotRules parses a comma separated list of min-side=<px>, max-ratio=<ratio> and <type>=<width>x<height>
+func ParseScreenshotRules(list string) (ScreenshotRules, error) {
+	rules := ScreenshotRules{resolutions: make(map[string]map[[2]int]bool)}
+	for _, rule := range strings.Split(list, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			return rules, fmt.Errorf("expected name=value, got %s", rule)
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		switch name {
+		case "min-side":
+			side, err := strconv.Atoi(value)
+			if err != nil {
+				return rules, err
+			}
+			rules.minSide = side
+		case "max-ratio":
+			ratio, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return rules, err
+			}
+			rules.maxRatio = ratio
+		default:
+			t := name
+			if t != "*" {
+				var ok bool
+				t, ok = ImageTypes[name]
+				if !ok {
+					return rules, fmt.Errorf("unknown type: %s", name)
+				}
+			}
+			var w, h int
+			if _, err := fmt.Sscanf(value, "%dx%d", &w, &h); err != nil {
+				return rules, fmt.Errorf("expected <width>x<height>, got %s", value)
+			}
+			if w > h {
+				w, h = h, w
+			}
+			if rules.resolutions[t] == nil {
+				rules.resolutions[t] = make(map[[2]int]bool)
+			}
+			rules.resolutions[t][[2]int{w, h}] = true
+		}
+	}
+	return rules, nil
+}
+
+// isScreenshot reports whether an image looks like a meme or screenshot according to the rules, and why.
+func (r ScreenshotRules) isScreenshot(cfg image.Config, imgType string) (bool, string) {
+	short, long := cfg.Width, cfg.Height
+	if short > long {
+		short, long = long, short
+	}
+	if long < r.minSide {
+		return true, fmt.Sprintf("tiny image (%dx%d)", cfg.Width, cfg.Height)
+	}
+	if r.maxRatio > 0 && short > 0 && float64(long)/float64(short) > r.maxRatio {
+		return true, fmt.Sprintf("extreme aspect ratio (%dx%d)", cfg.Width, cfg.Height)
+	}
+	res := [2]int{short, long}
+	if r.resolutions[imgType][res] || r.resolutions["*"][res] {
+		return true, fmt.Sprintf("screenshot resolution (%s %dx%d)", imgType, cfg.Width, cfg.Height)
+	}
+	return false, ""
+}
@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// fetchSelfPost writes the title and text of a self post as markdown for -save-text.
+func (dl *Downloader) fetchSelfPost(submission Submission) error {
+	if dl.SkipDuplicates {
+		if _, exists := dl.knownUrls[submission.Url]; exists {
+			dl.logSkip(submission, submission.Url, "duplicate", "skipping %s\n", submission.Url)
+			return nil
+		}
+		dl.knownUrls[submission.Url] = ""
+	}
+	if !dl.WritesFiles() {
+		return nil
+	}
+
+	p := dl.renderTextPath(submission)
+	if !dl.Overwrite {
+		if dl.outputExists(submission, p) {
+			dl.logSkip(submission, submission.Url, "file exists", "saving text of %s => file exists, overwrite disabled", submission.Permalink)
+			return nil
+		}
+	}
+
+	var text bytes.Buffer
+	_, _ = fmt.Fprintf(&text, "# %s\n\n", submission.Title)
+	if submission.Selftext != "" {
+		_, _ = fmt.Fprintf(&text, "%s\n\n", submission.Selftext)
+	}
+	_, _ = fmt.Fprintf(&text, "u/%s, https://www.reddit.com%s\n", submission.Author, submission.Permalink)
+
+	err := dl.writeOutput(submission, p, text.Bytes())
+	if err != nil {
+		dl.LogFailure("saving text of %s => %v", submission.Permalink, err)
+		return err
+	}
+	dl.knownUrls[submission.Url] = p
+	dl.countDownload(submission, text.Len())
+	dl.writeRawMetadata(submission, p)
+	dl.mirrorFile(p, submission.Url, submission)
+	dl.logSave(DownloadEvent{Submission: submission, Url: submission.Url, Path: p, Bytes: text.Len()}, "saving text of %s => %s\n", submission.Permalink, p)
+	return nil
+}
+
+// renderTextPath renders -text-template for a self post
+func (dl *Downloader) renderTextPath(submission Submission) string {
+	created := time.Unix(int64(submission.CreatedUtc), 0)
+
+	templateData := struct {
+		Ext          string
+		OriginalName string
+		Submission   Submission
+		Time         time.Time
+		Timestamp    string
+		Now          time.Time
+	}{
+		Ext:          ".md",
+		OriginalName: submission.Id,
+		Submission:   submission,
+		Time:         created,
+		Timestamp:    created.Format("2006-01-02-15-04-05"),
+		Now:          time.Now(),
+	}
+
+	var name bytes.Buffer
+	err := dl.TextTemplate.Execute(&name, templateData)
+	if err != nil {
+		panic(fmt.Errorf("template error: %v", err))
+	}
+
+	p := name.String()
+	if dl.SanitizePaths {
+		p = sanitizePath(p)
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dl.OutputRoot, p)
+	}
+	return p
+}
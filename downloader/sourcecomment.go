@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// commentUrlPattern matches urls in comment markdown, including the ones of [text](url) links
+var commentUrlPattern = regexp.MustCompile(`https?://[^\s()\[\]<>"]+`)
+
+// sourceUrl returns the first url in the top-level comments of the submission's author that links to an image
+// the downloader can fetch, or "" if there is none. It costs one request per submission.
+func (dl *Downloader) sourceUrl(submission Submission) string {
+	if !strings.HasPrefix(submission.Permalink, "/") || !strings.Contains(submission.Permalink, "/comments/") {
+		// urls from -urls-file and the like have no comments
+		return ""
+	}
+	<-dl.throttler
+	comments, err := dl.redditClient.GetTopLevelComments(submission.Permalink)
+	if err != nil {
+		log.Printf("fetching the comments of %s => %v, using the post's image", submission.Permalink, err)
+		return ""
+	}
+	for _, comment := range comments {
+		if !comment.IsSubmitter {
+			continue
+		}
+		for _, u := range commentUrlPattern.FindAllString(comment.Body, -1) {
+			u = strings.TrimRight(u, ".,;:!?*_")
+			if isSourceUrl(u) {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// isSourceUrl reports whether u is an imgur page or a direct link to an image, which are the sources the downloader
+// can fetch. Pixiv and twitter pages need their apis, and pixiv refuses images linked from elsewhere.
+func isSourceUrl(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.TrimPrefix(strings.ToLower(parsed.Host), "www.") {
+	case "imgur.com", "m.imgur.com":
+		return strings.Trim(parsed.Path, "/") != ""
+	case "i.pximg.net":
+		return false
+	case "pbs.twimg.com":
+		// e.g. https://pbs.twimg.com/media/<id>?format=jpg&name=orig
+		return strings.HasPrefix(parsed.Path, "/media/")
+	}
+	_, ok := imageExtensions[strings.ToLower(path.Ext(parsed.Path))]
+	return ok
+}
+
+// fetchSource downloads the source u linked by the submission's author instead of the submission's image.
+// It returns errImageNotFound if the source is gone, so the submission's image can be fetched instead.
+func (dl *Downloader) fetchSource(submission Submission, u string) error {
+	log.Printf("fetching %s (%s) => using the source %s linked by the author", submission.Url, submission.Permalink, u)
+	parsed, _ := url.Parse(u)
+	if host := strings.TrimPrefix(strings.ToLower(parsed.Host), "www."); host == "imgur.com" || host == "m.imgur.com" {
+		source := submission
+		source.Url = u
+		source.Domain = "imgur.com"
+		return dl.FetchImgur(source)
+	}
+	return dl.tryFetchSingleImage(u, submission)
+}
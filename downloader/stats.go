@@ -0,0 +1,205 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Stats are the counters of the current run, served by -http-addr
+type Stats struct {
+	Started     time.Time `json:"started"`
+	Submissions int       `json:"submissions"`
+	Downloaded  int       `json:"downloaded"`
+	Bytes       int64     `json:"bytes"`
+	Skipped     int       `json:"skipped"`
+	// SkipReasons counts the skips by their reason without the numbers and quoted values, see skipReasonKey
+	SkipReasons map[string]int `json:"skip_reasons"`
+	Failed      int            `json:"failed"`
+	// Listed counts the submissions fetched from listings, the ones that weren't handled yet are queued
+	Listed int `json:"listed"`
+	// Targets is the listing progress per target (subreddit, user, ...)
+	Targets map[string]TargetStats `json:"targets"`
+	// Subreddits are the counts per subreddit the submissions were posted to
+	Subreddits map[string]SubredditStats `json:"subreddits"`
+	// Hosts are the request latencies per host with -host-timings
+	Hosts map[string]HostStats `json:"hosts,omitempty"`
+}
+
+type TargetStats struct {
+	Pages     int  `json:"pages"`
+	Completed bool `json:"completed"`
+	// Downloaded counts the files written for submissions of the target's listing
+	Downloaded int `json:"downloaded"`
+}
+
+type SubredditStats struct {
+	Submissions int   `json:"submissions"`
+	Downloaded  int   `json:"downloaded"`
+	Bytes       int64 `json:"bytes"`
+}
+
+func (dl *Downloader) countSkip(reason string) {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	dl.stats.Skipped++
+	dl.stats.SkipReasons[skipReasonKey(reason)]++
+}
+
+// skipReasonKey cuts reason before the first number, quote or parenthesis, e.g. "score below 10 (has 3)"
+// and "score below 20 (has 5)" are both counted as "score below"
+func skipReasonKey(reason string) string {
+	if i := strings.IndexAny(reason, "0123456789\"("); i >= 0 {
+		reason = reason[:i]
+	}
+	return strings.TrimSpace(reason)
+}
+
+func (dl *Downloader) countFailure() {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	dl.stats.Failed++
+}
+
+func (dl *Downloader) countSubmission(submission Submission) {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	dl.stats.Submissions++
+	sub := dl.stats.Subreddits[submission.Subreddit]
+	sub.Submissions++
+	dl.stats.Subreddits[submission.Subreddit] = sub
+}
+
+func (dl *Downloader) countDownload(submission Submission, size int) {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	dl.stats.Downloaded++
+	dl.stats.Bytes += int64(size)
+	sub := dl.stats.Subreddits[submission.Subreddit]
+	sub.Downloaded++
+	sub.Bytes += int64(size)
+	dl.stats.Subreddits[submission.Subreddit] = sub
+	if submission.Target != "" {
+		t := dl.stats.Targets[describeTarget(submission.Target)]
+		t.Downloaded++
+		dl.stats.Targets[describeTarget(submission.Target)] = t
+	}
+}
+
+// targetDownloads returns the number of files written for submissions of target
+func (dl *Downloader) targetDownloads(target string) int {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	return dl.stats.Targets[describeTarget(target)].Downloaded
+}
+
+func (dl *Downloader) countPage(target string, listed int, completed bool) {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	dl.stats.Listed += listed
+	t := dl.stats.Targets[describeTarget(target)]
+	t.Pages++
+	t.Completed = completed
+	dl.stats.Targets[describeTarget(target)] = t
+}
+
+// Stats returns a copy of the counters of the run so far
+func (dl *Downloader) Stats() Stats {
+	return dl.snapshotStats()
+}
+
+func (dl *Downloader) snapshotStats() Stats {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	snapshot := dl.stats
+	snapshot.Targets = make(map[string]TargetStats, len(dl.stats.Targets))
+	for name, t := range dl.stats.Targets {
+		snapshot.Targets[name] = t
+	}
+	snapshot.SkipReasons = make(map[string]int, len(dl.stats.SkipReasons))
+	for reason, n := range dl.stats.SkipReasons {
+		snapshot.SkipReasons[reason] = n
+	}
+	snapshot.Subreddits = make(map[string]SubredditStats, len(dl.stats.Subreddits))
+	for name, sub := range dl.stats.Subreddits {
+		snapshot.Subreddits[name] = sub
+	}
+	snapshot.Hosts = dl.hostStats()
+	return snapshot
+}
+
+var statsTemplate = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>reddit-image-downloader</title>
+<style>
+body { font-family: sans-serif; background: #1a1a1b; color: #d7dadc; }
+td, th { padding: 2px 12px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<p>running for {{.Running}}: {{.Stats.Submissions}} submissions, {{.Stats.Downloaded}} downloaded ({{.Stats.Bytes}} bytes), {{.Stats.Skipped}} skipped, {{.Stats.Failed}} failed</p>
+<table>
+<tr><th></th><th>pages</th><th>downloaded</th><th></th></tr>
+{{range $name, $t := .Stats.Targets}}<tr><td>{{$name}}</td><td>{{$t.Pages}}</td><td>{{$t.Downloaded}}</td><td>{{if $t.Completed}}completed{{end}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th></th><th>submissions</th><th>downloaded</th><th>bytes</th></tr>
+{{range $name, $sub := .Stats.Subreddits}}<tr><td>r/{{$name}}</td><td>{{$sub.Submissions}}</td><td>{{$sub.Downloaded}}</td><td>{{$sub.Bytes}}</td></tr>
+{{end}}</table>
+{{if .Stats.Hosts}}<table>
+<tr><th></th><th>requests</th><th>failed</th><th>p50 ms</th><th>p95 ms</th></tr>
+{{range $host, $h := .Stats.Hosts}}<tr><td>{{$host}}</td><td>{{$h.Requests}}</td><td>{{$h.Errors}}</td><td>{{$h.P50Ms}}</td><td>{{$h.P95Ms}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// StartStatsServer serves the stats as json at /stats and as a html page at / on addr.
+func (dl *Downloader) StartStatsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(dl.snapshotStats())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		s := dl.snapshotStats()
+		// maps are ranged in key order by the template
+		_ = statsTemplate.Execute(w, struct {
+			Stats   Stats
+			Running string
+		}{
+			Stats:   s,
+			Running: time.Since(s.Started).Round(time.Second).String(),
+		})
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("serving stats on %s => %v", addr, err)
+		}
+	}()
+	log.Printf("serving stats on %s", addr)
+	return server
+}
+
+func StopStatsServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("stopping the stats server => %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Targets are what the listings are fetched for. They are normalized by NormalizeTarget to one of
+//   <subreddit>              e.g. pics, also pics+aww
+//   u/<user>                 submissions of a user
+//   u/<user>/m/<multireddit> a user's multireddit
+//   domain:<domain>          submissions linking to a domain
+
+// targets starting with this are domains (e.g. domain:imgur.com) instead of subreddits
+const domainPrefix = "domain:"
+
+const userPrefix = "u/"
+
+// ReadTargetsFile reads the targets in p (one per line) for -subreddits-file.
+func ReadTargetsFile(p string) ([]string, error) {
+	lines, err := ReadLines(p)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(lines))
+	for _, line := range lines {
+		target, err := NormalizeTarget(line)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// MergeTargets appends the targets of b that aren't in a.
+func MergeTargets(a []string, b []string) []string {
+	merged := append([]string(nil), a...)
+	seen := make(map[string]bool)
+	for _, target := range a {
+		seen[target] = true
+	}
+	for _, target := range b {
+		if !seen[target] {
+			seen[target] = true
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
+
+// NormalizeTarget turns the ways users write subreddits (r/pics, /r/pics/, https://www.reddit.com/r/pics/new)
+// and users or multireddits into the canonical target form.
+func NormalizeTarget(arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(arg, domainPrefix) {
+		return arg, nil
+	}
+
+	if strings.Contains(arg, "://") || strings.Contains(arg, "reddit.com/") {
+		if !strings.Contains(arg, "://") {
+			arg = "https://" + arg
+		}
+		u, err := url.Parse(arg)
+		if err != nil {
+			return "", err
+		}
+		if u.Host != "reddit.com" && !strings.HasSuffix(u.Host, ".reddit.com") {
+			return "", fmt.Errorf("not a reddit url: %s", arg)
+		}
+		arg = u.Path
+	}
+
+	parts := strings.FieldsFunc(arg, func(r rune) bool { return r == '/' })
+	if len(parts) == 0 {
+		return "", fmt.Errorf("empty subreddit")
+	}
+	switch strings.ToLower(parts[0]) {
+	case "r":
+		if len(parts) < 2 {
+			return "", fmt.Errorf("missing subreddit name: %s", arg)
+		}
+		return parts[1], nil
+	case "u", "user":
+		if len(parts) < 2 {
+			return "", fmt.Errorf("missing user name: %s", arg)
+		}
+		if len(parts) >= 4 && strings.ToLower(parts[2]) == "m" {
+			return userPrefix + parts[1] + "/m/" + parts[3], nil
+		}
+		return userPrefix + parts[1], nil
+	case "m":
+		return "", fmt.Errorf("multireddits need their owner, use u/<user>/m/<name>: %s", arg)
+	}
+	if len(parts) > 1 {
+		return "", fmt.Errorf("invalid subreddit: %s", arg)
+	}
+	return parts[0], nil
+}
+
+func describeTarget(target string) string {
+	if strings.HasPrefix(target, domainPrefix) {
+		return "domain " + strings.TrimPrefix(target, domainPrefix)
+	} else if strings.HasPrefix(target, userPrefix) {
+		return target
+	}
+	return "r/" + target
+}
+
+// mapUserSubreddit returns submission with the subreddit u_<name>, which is how reddit calls profile posts,
+// if it was listed in the feed of the user <name> and UserSubredditMap is set. Multireddits have posts of many users
+// and keep the subreddits.
+func (dl *Downloader) mapUserSubreddit(submission Submission) Submission {
+	if !dl.UserSubredditMap || !strings.HasPrefix(submission.Target, userPrefix) {
+		return submission
+	}
+	user := strings.TrimPrefix(submission.Target, userPrefix)
+	if strings.Contains(user, "/") {
+		return submission
+	}
+	submission.Subreddit = "u_" + user
+	return submission
+}
+
+// fetchListing fetches one page of a target's listing. The search is only applied to subreddits.
+func (dl *Downloader) fetchListing(target string, after string, limit int, search *string) (Listing, error) {
+	params := NewListingParams{
+		After: after,
+		Limit: limit,
+		Geo:   dl.Geo,
+	}
+	if strings.HasPrefix(target, domainPrefix) {
+		params.Sort = dl.DomainSort
+		params.Time = dl.DomainTime
+		return dl.redditClient.GetDomain(strings.TrimPrefix(target, domainPrefix), params)
+	} else if strings.HasPrefix(target, userPrefix) {
+		parts := strings.Split(strings.TrimPrefix(target, userPrefix), "/")
+		if len(parts) == 3 {
+			return dl.redditClient.GetMulti(parts[0], parts[2], params)
+		}
+		return dl.redditClient.GetUser(parts[0], params)
+	} else if search != nil {
+		return dl.redditClient.GetSearch(target, SearchListingParams{
+			After:  after,
+			Limit:  limit,
+			Search: *search,
+			Sort:   dl.SearchSort,
+			Time:   dl.SearchTime,
+			Geo:    dl.Geo,
+		})
+	}
+	return dl.redditClient.GetNew(target, params)
+}
+
+// ParseGeo checks a -geo country code (ISO 3166 alpha-2 or GLOBAL) and returns it in upper case
+func ParseGeo(geo string) (string, error) {
+	geo = strings.ToUpper(strings.TrimSpace(geo))
+	if geo == "" || geo == "GLOBAL" {
+		return geo, nil
+	}
+	if len(geo) != 2 || geo[0] < 'A' || geo[0] > 'Z' || geo[1] < 'A' || geo[1] > 'Z' {
+		return "", fmt.Errorf("%s is not a two letter country code or GLOBAL", geo)
+	}
+	return geo, nil
+}
+
+// CheckDomainSort validates -domain-sort and -domain-time
+func CheckDomainSort(sort string, time string) error {
+	switch sort {
+	case "new", "top", "hot", "rising", "controversial":
+	default:
+		return fmt.Errorf("unknown sort %s", sort)
+	}
+	switch time {
+	case "":
+		return nil
+	case "hour", "day", "week", "month", "year", "all":
+	default:
+		return fmt.Errorf("unknown time window %s", time)
+	}
+	if sort != "top" && sort != "controversial" {
+		return fmt.Errorf("the time window doesn't apply to sort %s", sort)
+	}
+	return nil
+}
+
+// CheckSearchSort validates -search-sort and -search-time
+func CheckSearchSort(sort string, time string) error {
+	switch sort {
+	case "new", "top", "relevance", "hot", "comments":
+	default:
+		return fmt.Errorf("unknown sort %s", sort)
+	}
+	switch time {
+	case "":
+		return nil
+	case "hour", "day", "week", "month", "year", "all":
+	default:
+		return fmt.Errorf("unknown time window %s", time)
+	}
+	if sort == "new" || sort == "hot" {
+		return fmt.Errorf("the time window doesn't apply to sort %s", sort)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package downloader
+
+import "os"
+
+// terminalSize can't be determined on this platform, -tui assumes 80x24
+func terminalSize(f *os.File) (columns int, rows int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+const adaptiveMinInterval = 500 * time.Millisecond
+const adaptiveMaxInterval = time.Minute
+const adaptiveStep = 250 * time.Millisecond
+
+// failed listing requests are retried after retryMinBackoff, doubling up to retryMaxBackoff,
+// independent of -throttle, which can be 0
+const retryMinBackoff = time.Second
+const retryMaxBackoff = 2 * time.Minute
+
+// number of successful requests in a row after which the interval is decreased
+const adaptiveCleanStreak = 10
+
+// AdaptiveThrottle ticks like the immediate ticker, but adjusts its interval AIMD-style:
+// it starts fast, doubles the interval on every rate limit and decreases it by a fixed step after a clean streak.
+type AdaptiveThrottle struct {
+	C <-chan time.Time
+
+	mu       sync.Mutex
+	interval time.Duration
+	streak   int
+	done     chan struct{}
+	once     sync.Once
+}
+
+// ImmediateTicker is like time.Ticker, but also ticks right away instead of only after the first interval.
+// It is a token bucket: up to burst ticks are kept while nobody receives, and it starts full.
+type ImmediateTicker struct {
+	C <-chan time.Time
+
+	ticker *time.Ticker
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newImmediateTicker(repeat time.Duration, burst int) *ImmediateTicker {
+	if burst < 1 {
+		burst = 1
+	}
+	ticker := time.NewTicker(repeat)
+	nc := make(chan time.Time, burst)
+	for i := 0; i < burst; i++ {
+		nc <- time.Now()
+	}
+	t := &ImmediateTicker{C: nc, ticker: ticker, done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case tm := <-ticker.C:
+				select {
+				case nc <- tm:
+				default:
+					// the bucket is full
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Stop turns off the ticker and ends its forwarding goroutine. Like time.Ticker, C is not closed.
+func (t *ImmediateTicker) Stop() {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+	})
+}
+
+// nextRetryBackoff returns the wait before the next retry of a request that already waited previous, 0 for the first retry
+func nextRetryBackoff(previous time.Duration) time.Duration {
+	if previous < retryMinBackoff {
+		return retryMinBackoff
+	}
+	if previous*2 > retryMaxBackoff {
+		return retryMaxBackoff
+	}
+	return previous * 2
+}
+
+// unthrottled returns a channel that never blocks, for -throttle 0.
+func unthrottled() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}
+
+func newAdaptiveThrottle() *AdaptiveThrottle {
+	c := make(chan time.Time)
+	t := &AdaptiveThrottle{C: c, interval: adaptiveMinInterval, done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case c <- time.Now():
+			case <-t.done:
+				return
+			}
+			select {
+			case <-time.After(t.Interval()):
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Stop ends the goroutine feeding C.
+func (t *AdaptiveThrottle) Stop() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
+
+func (t *AdaptiveThrottle) Interval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interval
+}
+
+// RateLimited is called when reddit answered with 429.
+func (t *AdaptiveThrottle) RateLimited() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.streak = 0
+	t.interval *= 2
+	if t.interval > adaptiveMaxInterval {
+		t.interval = adaptiveMaxInterval
+	}
+}
+
+// Success is called after every request that wasn't rate limited.
+func (t *AdaptiveThrottle) Success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.streak++
+	if t.streak >= adaptiveCleanStreak {
+		t.streak = 0
+		t.interval -= adaptiveStep
+		if t.interval < adaptiveMinInterval {
+			t.interval = adaptiveMinInterval
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImmediateTickerTicksRightAway(t *testing.T) {
+	ticker := newImmediateTicker(time.Hour, 1)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("no immediate tick")
+	}
+}
+
+func TestImmediateTickerSpacing(t *testing.T) {
+	interval := 50 * time.Millisecond
+	ticker := newImmediateTicker(interval, 1)
+	defer ticker.Stop()
+	<-ticker.C
+	start := time.Now()
+	<-ticker.C
+	<-ticker.C
+	// the first tick after the immediate one can come a little early, it is timed from the ticker's creation
+	if elapsed := time.Since(start); elapsed < interval {
+		t.Errorf("two ticks after %s, want at least %s", elapsed, interval)
+	}
+}
+
+func TestImmediateTickerBurst(t *testing.T) {
+	ticker := newImmediateTicker(time.Hour, 3)
+	defer ticker.Stop()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticker.C:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("tick %d of the burst missing", i+1)
+		}
+	}
+	select {
+	case <-ticker.C:
+		t.Fatal("tick beyond the burst")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestImmediateTickerKeepsAtMostBurst(t *testing.T) {
+	interval := 20 * time.Millisecond
+	ticker := newImmediateTicker(interval, 2)
+	defer ticker.Stop()
+	// nobody receives for several intervals, the bucket stays at 2
+	time.Sleep(10 * interval)
+	received := 0
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			received++
+		case <-time.After(interval / 2):
+			done = true
+		}
+	}
+	if received > 3 {
+		t.Errorf("received %d ticks at once, want at most the burst of 2 (and one that came in meanwhile)", received)
+	}
+}
+
+func TestNextRetryBackoff(t *testing.T) {
+	var got []time.Duration
+	backoff := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		backoff = nextRetryBackoff(backoff)
+		got = append(got, backoff)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		32 * time.Second, 64 * time.Second, retryMaxBackoff, retryMaxBackoff, retryMaxBackoff}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoffs %v, want %v", got, want)
+		}
+	}
+}
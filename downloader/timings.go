@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// hostTimingSamples is the number of latencies kept per host, percentiles are of the most recent ones
+const hostTimingSamples = 10000
+
+// HostStats are the request latencies of a host with -host-timings, the time until the response headers arrived
+type HostStats struct {
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+}
+
+type hostTiming struct {
+	requests int
+	errors   int
+	// ring buffer of the last hostTimingSamples latencies
+	samples []time.Duration
+	next    int
+}
+
+// timingTransport records the latency of every request by host
+type timingTransport struct {
+	next http.RoundTripper
+	dl   *Downloader
+}
+
+func (t timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.dl.recordTiming(req.URL.Hostname(), time.Since(start), err != nil)
+	return resp, err
+}
+
+// EnableHostTimings records the latency of reddit, imgur and image requests per host, which is served by
+// the stats server and logged by Finish. Call it after setting the transports of HttpClient and ImageClient.
+func (dl *Downloader) EnableHostTimings() {
+	dl.hostTimings = make(map[string]*hostTiming)
+	for _, client := range []*http.Client{&dl.HttpClient, &dl.ImageClient} {
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = timingTransport{next: next, dl: dl}
+	}
+}
+
+func (dl *Downloader) recordTiming(host string, d time.Duration, failed bool) {
+	dl.statsMu.Lock()
+	defer dl.statsMu.Unlock()
+	h := dl.hostTimings[host]
+	if h == nil {
+		h = &hostTiming{}
+		dl.hostTimings[host] = h
+	}
+	h.requests++
+	if failed {
+		h.errors++
+		return
+	}
+	if len(h.samples) < hostTimingSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hostTimingSamples
+	}
+}
+
+// hostStats returns the latency percentiles per host, nil without -host-timings. statsMu must be held.
+func (dl *Downloader) hostStats() map[string]HostStats {
+	if dl.hostTimings == nil {
+		return nil
+	}
+	hosts := make(map[string]HostStats, len(dl.hostTimings))
+	for host, h := range dl.hostTimings {
+		sorted := append([]time.Duration(nil), h.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		hosts[host] = HostStats{
+			Requests: h.requests,
+			Errors:   h.errors,
+			P50Ms:    percentileMs(sorted, 50),
+			P95Ms:    percentileMs(sorted, 95),
+		}
+	}
+	return hosts
+}
+
+// percentileMs returns the nearest-rank percentile of sorted latencies in milliseconds
+func percentileMs(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
+
+// logHostTimings logs the latencies per host, slowest p95 first
+func (dl *Downloader) logHostTimings() {
+	dl.statsMu.Lock()
+	hosts := dl.hostStats()
+	dl.statsMu.Unlock()
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Slice(names, func(i, j int) bool { return hosts[names[i]].P95Ms > hosts[names[j]].P95Ms })
+	for _, host := range names {
+		h := hosts[host]
+		log.Printf("timings of %s => %d requests (%d failed), p50 %.0fms, p95 %.0fms", host, h.Requests, h.Errors, h.P50Ms, h.P95Ms)
+	}
+}
@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func ParseTitleNormalization(s string) (string, error) {
+	switch s {
+	case "slug", "lower", "exact":
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown title normalization %s, use slug, lower or exact", s)
+}
+
+// normalizeTitle returns the title as compared by -dedupe-titles
+func (dl *Downloader) normalizeTitle(title string) string {
+	switch dl.TitleNormalization {
+	case "slug":
+		return slugify(title)
+	case "lower":
+		return strings.ToLower(strings.TrimSpace(title))
+	}
+	return title
+}
+
+// ReadTitlesFile loads the titles seen by earlier runs, a missing file is empty.
+// Lines are "<unix time of the last sighting>\t<title>", older files without the time count as seen now.
+// With DedupeWindow, titles last seen before the window are dropped and the file is rewritten without them.
+func (dl *Downloader) ReadTitlesFile(p string) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	now := time.Now()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		lines++
+		seen := now
+		if i := strings.Index(line, "\t"); i > 0 {
+			if sec, err := strconv.ParseInt(line[:i], 10, 64); err == nil {
+				seen = time.Unix(sec, 0)
+				line = line[i+1:]
+			}
+		}
+		if last, ok := dl.seenTitles[line]; !ok || seen.After(last) {
+			dl.seenTitles[line] = seen
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if dl.DedupeWindow <= 0 {
+		return nil
+	}
+	for t, last := range dl.seenTitles {
+		if now.Sub(last) > dl.DedupeWindow {
+			delete(dl.seenTitles, t)
+		}
+	}
+	if lines == len(dl.seenTitles) || !dl.WritesFiles() {
+		return nil
+	}
+	return dl.rewriteTitlesFile(p)
+}
+
+// rewriteTitlesFile replaces the titles file with one line per remembered title
+func (dl *Downloader) rewriteTitlesFile(p string) error {
+	var data bytes.Buffer
+	for t, last := range dl.seenTitles {
+		_, _ = fmt.Fprintf(&data, "%d\t%s\n", last.Unix(), t)
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// seenTitle reports whether the normalized title was processed before (within DedupeWindow, if set)
+// and remembers it otherwise. Titles that normalize to nothing (e.g. only emoji with slug) are never seen.
+// With a window, every sighting renews the title, so titles that keep coming back stay within it.
+func (dl *Downloader) seenTitle(title string) bool {
+	t := dl.normalizeTitle(title)
+	if t == "" {
+		return false
+	}
+	now := time.Now()
+	last, seen := dl.seenTitles[t]
+	if seen && dl.DedupeWindow <= 0 {
+		return true
+	}
+	if seen && now.Sub(last) > dl.DedupeWindow {
+		seen = false
+	}
+	dl.seenTitles[t] = now
+	// dry runs don't change what later runs skip
+	if dl.TitlesFile != "" && dl.WritesFiles() {
+		f, err := os.OpenFile(dl.TitlesFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\t%s\n", now.Unix(), t)
+			closeErr := f.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			log.Printf("writing %s => %v", dl.TitlesFile, err)
+		}
+	}
+	return seen
+}
@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ReadLines reads one entry (url or target) per line, ignoring empty lines and lines starting with #.
+func ReadLines(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// ReadListingFile reads a saved reddit listing (e.g. the output of /r/<subreddit>/new.json) or an array of them
+// (e.g. the output of a comments page) and returns the non-meta submissions.
+func ReadListingFile(p string) ([]Submission, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var listings []Listing
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &listings)
+	} else {
+		var listing Listing
+		err = json.Unmarshal(data, &listing)
+		listings = append(listings, listing)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var submissions []Submission
+	for _, listing := range listings {
+		for _, submission := range listing.Children {
+			// comment listings contain comments (t1), not submissions (t3)
+			if (submission.Kind != "" && submission.Kind != "t3") || submission.IsMeta {
+				continue
+			}
+			submissions = append(submissions, submission)
+		}
+	}
+	return submissions, nil
+}
+
+// UrlSubmission wraps a direct image url in a submission, so it can go through the normal pipeline.
+// The id is the last path segment without extension, e.g. abc123 for https://i.imgur.com/abc123.jpg.
+func UrlSubmission(u string, subreddit string) Submission {
+	var submission Submission
+	submission.Url = u
+	submission.Permalink = u
+	submission.Subreddit = subreddit
+	submission.PostHint = "image"
+	submission.CreatedUtc = float64(time.Now().Unix())
+	if parsed, err := url.Parse(u); err == nil {
+		submission.Domain = parsed.Host
+		base := path.Base(parsed.Path)
+		submission.Id = strings.TrimSuffix(base, path.Ext(base))
+	}
+	submission.Name = submission.Id
+	submission.Title = submission.Id
+	return submission
+}
+
+// PostSubmission fetches the submission of a reddit post url (https://www.reddit.com/r/<subreddit>/comments/<id>/...) for -post
+func (dl *Downloader) PostSubmission(u string) (Submission, error) {
+	if !isRedditPostUrl(u) {
+		return Submission{}, fmt.Errorf("not a reddit post url: %s", u)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return Submission{}, err
+	}
+	<-dl.throttler
+	return dl.redditClient.GetPost(parsed.Path)
+}
+
+// AlbumSubmission wraps an imgur album url (https://imgur.com/a/<id>) in a submission for -album-url.
+func AlbumSubmission(u string, subreddit string) (Submission, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return Submission{}, err
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(parsed.Host, "www."), "m.")
+	id := strings.Trim(strings.TrimPrefix(parsed.Path, "/a/"), "/")
+	if host != "imgur.com" || !strings.HasPrefix(parsed.Path, "/a/") || id == "" || strings.Contains(id, "/") {
+		return Submission{}, fmt.Errorf("not an imgur album url: %s", u)
+	}
+
+	var submission Submission
+	submission.Url = "https://imgur.com/a/" + id
+	submission.Permalink = u
+	submission.Domain = "imgur.com"
+	submission.Subreddit = subreddit
+	submission.Id = id
+	submission.Name = id
+	submission.Title = id
+	submission.CreatedUtc = float64(time.Now().Unix())
+	return submission, nil
+}
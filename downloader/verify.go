@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var imageExtensions = map[string]struct{}{
+	".png":  {},
+	".jpg":  {},
+	".jpeg": {},
+	".gif":  {},
+	".webp": {},
+	".tif":  {},
+	".tiff": {},
+	".bmp":  {},
+}
+
+// VerifyArchive decodes every image below root and reports (and optionally deletes) the ones that fail to decode.
+// It returns the number of corrupt files.
+func VerifyArchive(root string, deleteCorrupt bool) (int, error) {
+	checked := 0
+	corrupt := 0
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if _, ok := imageExtensions[strings.ToLower(filepath.Ext(p))]; !ok {
+			return nil
+		}
+		checked++
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		// a full decode also catches truncated files, which DecodeConfig would accept
+		_, _, decodeErr := image.Decode(bytes.NewReader(data))
+		if decodeErr == nil {
+			return nil
+		}
+		corrupt++
+		if deleteCorrupt {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			log.Printf("verifying %s => %v, deleted", p, decodeErr)
+		} else {
+			log.Printf("verifying %s => %v", p, decodeErr)
+		}
+		return nil
+	})
+	log.Printf("verified %d images, %d corrupt", checked, corrupt)
+	return corrupt, err
+}
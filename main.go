@@ -1,690 +1,674 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"flag"
 	"fmt"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
-	"io"
-	"io/ioutil"
 	"log"
-	"mime"
+	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
-	"path"
-	"path/filepath"
-	"strconv"
+	"runtime"
 	"strings"
-	"text/template"
 	"time"
-	"unicode"
 
-	"github.com/gosimple/slug"
-	_ "golang.org/x/image/bmp"
-	_ "golang.org/x/image/tiff"
-	_ "golang.org/x/image/webp"
+	"reddit-image-downloader/downloader"
 )
 
-var singleTemplate *template.Template
-var albumTemplate *template.Template
+var outputRoots = outputRootsFlag{roots: []string{"."}}
 
-var outputRoot string
-
-var httpClient http.Client
-var redditClient RedditClient
-var imgurClient ImgurClient
-
-var skipDuplicates bool
-var skipDuplicatesInAlbums bool
-var noAlbums bool
-var maxPages bool
-
-var knownUrls = make(map[string]struct{})
-var knownHashes = make(map[string]struct{})
-
-var quiet bool
-var overwrite bool
-var nsfw bool
-
-var minWidth int
-var maxWidth int
-var minHeight int
-var maxHeight int
-var maxAspect float64
-
-var noPortrait bool
-var noLandscape bool
-var noSquare bool
-
-var parseImages bool
-
-var minSize int
-var maxSize int
+// outputRootsFlag collects repeated -out options, the first one replaces the default
+type outputRootsFlag struct {
+	roots []string
+	set   bool
+}
 
-var allowTypes = make(map[string]struct{})
+func (f *outputRootsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.roots, ",")
+}
 
-var throttler *time.Ticker
+func (f *outputRootsFlag) Set(value string) error {
+	if !f.set {
+		f.roots = nil
+		f.set = true
+	}
+	f.roots = append(f.roots, value)
+	return nil
+}
 
 func main() {
-	defaultSingleTemplateStr := `{{.Submission.Subreddit}}/{{.Timestamp}}-{{.Submission.Id}}-{{.Submission.Title | slugify}}{{.Ext}}`
-	defaultAlbumTemplateStr := `{{.Submission.Subreddit}}/{{.Timestamp}}-{{.Submission.Id}}-{{.Submission.Title | slugify}}/{{.Num}}-{{.Image.Hash}}{{.Ext}}`
-
-	singleTemplateStr := flag.String("single-template", defaultSingleTemplateStr, "template for image paths, use go template syntax")
-	albumTemplateStr := flag.String("album-template", defaultAlbumTemplateStr, "template for image paths in albums, use go template syntax")
-	flag.StringVar(&outputRoot, "out", ".", "root output directory")
-	flag.BoolVar(&noAlbums, "no-albums", false, "don't download albums")
-	flag.BoolVar(&skipDuplicates, "skip-duplicates", true, "skip duplicate single images")
-	flag.BoolVar(&skipDuplicatesInAlbums, "skip-duplicates-in-albums", false, "skip duplicate images within imgur albums")
-	throttle := flag.Duration("throttle", 2*time.Second, "wait at least this long between requests to the reddit api")
+	dl := downloader.New()
+
+	singleTemplateStr := flag.String("single-template", downloader.DefaultSingleTemplate, "template for image paths, use go template syntax")
+	textTemplateStr := flag.String("text-template", downloader.DefaultSingleTemplate, "template for the paths of self post texts (-save-text), use go template syntax")
+	albumTemplateStr := flag.String("album-template", downloader.DefaultAlbumTemplate, "template for image paths in albums, use go template syntax")
+	flag.Var(&outputRoots, "out", "root output directory, repeat to write every file to several directories")
+	flag.BoolVar(&dl.NoAlbums, "no-albums", false, "don't download albums")
+	flag.BoolVar(&dl.Embeds, "embeds", false, "download the thumbnails of embedded media (youtube, streamable, ...)")
+	flag.BoolVar(&dl.PreferMp4, "prefer-mp4", false, "download the mp4 version of animated images in imgur albums and reddit galleries (image filters are not applied to mp4s)")
+	preferOpt := flag.String("prefer", "", "try the formats of the same image in this order, e.g. mp4,png,jpg (imgur links without extension, animated album images and their mp4, images and their reddit preview)")
+	flag.IntVar(&dl.MinAlbumImages, "min-album-images", 0, "skip albums with fewer images")
+	flag.IntVar(&dl.MaxAlbumImages, "max-album-images", 0, "skip albums with more images (0 = off)")
+	flag.IntVar(&dl.AlbumLimit, "album-limit", 0, "download at most this many images per album (0 = off)")
+	flag.BoolVar(&dl.SanitizePaths, "sanitize-paths", runtime.GOOS == "windows", "replace characters that are invalid in file names on any OS (<>:\"|?*\\ and control characters) in rendered paths, for templates with unslugified fields")
+	flag.IntVar(&dl.ContactSheetColumns, "album-contact-sheet", 0, "write albums as one grid image (JPEG, path of -single-template) with this many columns instead of separate files (0 = off)")
+	flag.IntVar(&dl.ContactSheetCell, "contact-sheet-cell", downloader.DefaultContactSheetCell, "size in pixels of the square cells of -album-contact-sheet, images are scaled to fit")
+	flag.BoolVar(&dl.SingleImageAlbumsAsSingles, "single-image-albums-as-singles", false, "download albums with only one image like single images, using -single-template")
+	flag.BoolVar(&dl.SkipDuplicates, "skip-duplicates", true, "skip duplicate single images")
+	flag.BoolVar(&dl.HardlinkDuplicates, "hardlink-duplicates", false, "instead of skipping duplicates, link them to the already downloaded file (symlinks if hardlinks are not supported)")
+	flag.BoolVar(&dl.ExcludeAlreadyLinked, "exclude-already-linked", false, "skip imgur images that were already downloaded under a different url, e.g. as a single image and in an album")
+	flag.BoolVar(&dl.DedupePixels, "dedupe-pixels", false, "detect duplicates by their decoded pixels instead of their bytes, e.g. the same picture as JPEG and PNG (slower, needs a full decode of every image)")
+	flag.BoolVar(&dl.FastDedup, "fast-dedup", false, "skip duplicates by a fingerprint of their size and first -fast-dedup-bytes, fetched with a range request, and only download the full image if it's new (can rarely skip an image that isn't a duplicate)")
+	flag.IntVar(&dl.FastDedupBytes, "fast-dedup-bytes", downloader.DefaultFastDedupBytes, "number of leading bytes fingerprinted by -fast-dedup")
+	flag.BoolVar(&dl.SkipDuplicatesInAlbums, "skip-duplicates-in-albums", false, "skip duplicate images within imgur albums and reddit galleries")
+	listingConcurrency := flag.Uint("listing-concurrency", 1, "fetch the listings of this many subreddits at the same time, they still share -throttle but may burst")
+	imgurClientId := flag.String("imgur-client-id", "", "client id of a registered imgur application, to look up whether imgur.com/<hash> links are images or videos instead of guessing the extension, and imgur.com/gallery/<id> posts with the official api")
+	cookieFile := flag.String("cookie-file", "", "send the reddit.com cookies of this cookie file (Netscape format) with reddit requests, e.g. a reddit_session cookie for private subreddits you were approved for")
+	flag.BoolVar(&dl.AcceptQuarantine, "accept-quarantine", false, "accept the quarantine of quarantined subreddits for the account of -cookie-file, like clicking \"continue\" on reddit, and list them")
+	hostTimings := flag.Bool("host-timings", false, "record the latency of requests per host, log the p50 and p95 at the end and serve them with -http-addr")
+	proxyList := flag.String("proxy-list", "", "distribute image downloads round robin over the proxies in this file (one url per line, e.g. http://host:port or socks5://host:port)")
+	proxyRandom := flag.Bool("proxy-random", false, "pick a random proxy of -proxy-list for every request instead of round robin")
+	proxyApi := flag.Bool("proxy-api", false, "also send reddit and imgur api requests through -proxy-list")
+	metricsAddr := flag.String("metrics-addr", "", "serve the progress as Prometheus metrics at /metrics on this address, e.g. :9100")
+	httpAddr := flag.String("http-addr", "", "serve the progress as json at /stats and as a status page at / on this address, e.g. :8080")
+	throttle := flag.Duration("throttle", 2*time.Second, "wait at least this long between requests to the reddit api, 0 disables throttling")
+	writeThrottle := flag.Duration("write-throttle", 0, "wait at least this long between writing files, e.g. for slow SD cards (0 = off)")
+	adaptive := flag.Bool("adaptive-throttle", false, "instead of -throttle, start fast and slow down when reddit rate limits")
+	mergeSort := flag.Bool("merge-sort", false, "process the submissions of all subreddits newest first, instead of page by page (-random is ignored)")
+	random := flag.Bool("random", false, "process the submissions of every page in random order")
+	seed := flag.Int64("seed", 0, "random seed for -random (0 = random)")
+	flag.DurationVar(&dl.ImageTimeout, "image-timeout", 10*time.Second, "abandon image downloads that take longer than this (0 = off)")
+	failFastOnAuth := flag.Bool("fail-fast-on-auth", true, "exit when reddit denies a request with 401 or 403, instead of skipping the subreddit (private and quarantined subreddits are always skipped)")
+	retryEmpty := flag.Uint("retry-empty", 0, "list the subreddits that produced no download again after all subreddits are done, at most this many times (0 = off)")
+	subredditTimeout := flag.Duration("subreddit-timeout", 0, "give up on a subreddit after spending this long fetching its listings, including retries (0 = off)")
 	pageSize := flag.Uint("page-size", 25, "reddit api listing page size")
 	maxPages := flag.Uint("pages", 5, "maximum number of pages to download (default 5) (0 = off)")
 	search := flag.String("search", "", "search string")
+	flag.StringVar(&dl.SearchSort, "search-sort", "new", "sort order of -search (new|top|relevance|hot|comments)")
+	geoOpt := flag.String("geo", "", "request the listings as seen from this country (two letter code like DE, or GLOBAL), see the README for the listings reddit applies it to")
+	flag.StringVar(&dl.DomainSort, "domain-sort", "new", "sort order of domain:<domain> targets (new|top|hot|rising|controversial)")
+	flag.StringVar(&dl.DomainTime, "domain-time", "", "time window of domain:<domain> targets with -domain-sort top or controversial (hour|day|week|month|year|all)")
+	flag.StringVar(&dl.SearchTime, "search-time", "", "time window of -search with -search-sort top, relevance or comments (hour|day|week|month|year|all)")
 	orientation := flag.String("orientation", "all", "image orientation (landscape|portrait|square|all), separate multiple values with comma")
+	flag.Float64Var(&dl.SquareTolerance, "square-tolerance", 0, "treat images whose long side is at most this much longer than the short side as square for -orientation, e.g. 0.05 for 5%")
 	minWidthOpt := flag.Uint("min-width", 0, "minimum width")
 	minHeightOpt := flag.Uint("min-height", 0, "minimum height")
 	maxWidthOpt := flag.Uint("max-width", 0, "maximum width (0 = off)")
 	maxHeightOpt := flag.Uint("max-height", 0, "maximum height (0 = off)")
 	maxAspectOpt := flag.Float64("max-aspect-ratio", 0.0, "maximum aspect ratio (height / width) (0 = off)")
+	maxRuntime := flag.Duration("max-runtime", 0, "stop listing and downloading after this long, e.g. 30m for a scheduled job, and finish the archives, galleries and reports (0 = off)")
+	flag.DurationVar(&dl.MaxAge, "max-age", 0, "skip submissions older than this, e.g. 168h for a week, and stop paging a subreddit once its listing reaches them (0 = off)")
 	minScore := flag.Int("min-score", 0, "ignore submissions below this score")
-	flag.BoolVar(&quiet, "quiet", false, "don't print every submission (errors and skips are still printed)")
-	flag.BoolVar(&overwrite, "overwrite", false, "overwrite existing files")
-	flag.BoolVar(&nsfw, "nsfw", false, "include nsfw submissions")
-	allowedTypes := flag.String("type", "", "image type (png|jpe?g|gif|webp|tiff?|bmp), separate multiple values with with comma")
+	noCrossposts := flag.Bool("no-crossposts", false, "skip crossposts")
+	onlyCrossposts := flag.Bool("only-crossposts", false, "skip submissions that aren't crossposts")
+	flag.BoolVar(&dl.DedupeTitles, "dedupe-titles", false, "skip submissions whose title was already processed, see -title-normalization and -titles-file")
+	titleNormalizationOpt := flag.String("title-normalization", dl.TitleNormalization, "how -dedupe-titles compares titles (slug|lower|exact)")
+	flag.DurationVar(&dl.DedupeWindow, "dedupe-window", 0, "forget titles of -dedupe-titles and hashes of -hashes-file that weren't seen for this long, also in their files, e.g. 720h (0 = off)")
+	flag.StringVar(&dl.TitlesFile, "titles-file", "", "remember the titles of -dedupe-titles across runs in this file")
+	flag.StringVar(&dl.HashesFile, "hashes-file", "", "remember the hashes of downloaded files across runs in this file, so their duplicates are skipped in later runs")
+	flag.BoolVar(&dl.OnlyNewHashes, "only-new-hashes", false, "download and filter images without writing them and only add the hashes of new ones to -hashes-file")
+	onlyOc := flag.Bool("only-oc", false, "skip submissions that aren't marked as original content")
+	quiet := flag.Bool("quiet", false, "don't print every submission (errors and skips are still printed)")
+	tui := flag.Bool("tui", false, "show the progress per subreddit and the totals in place at the bottom of the terminal instead of logging every submission (errors are still logged, ignored if stderr isn't a terminal)")
+	quietSkips := flag.Bool("quiet-skips", false, "don't print skipped submissions and images (duplicates, filters, existing files)")
+	strict := flag.Bool("strict", false, "exit with status 1 if any download or write failed, e.g. also for a single dead link")
+	flag.BoolVar(&dl.Overwrite, "overwrite", false, "overwrite existing files")
+	flag.BoolVar(&dl.Nsfw, "nsfw", false, "include nsfw submissions")
+	allowedTypes := flag.String("type", "", "image type (png|jpe?g|gif|webp|tiff?|bmp|avif|heic), separate multiple values with with comma")
+	contentTypes := flag.String("content-type", "", "only download responses with this Content-Type header (e.g. image/jpeg or image/*), separate multiple values with comma")
+	flag.BoolVar(&dl.SkipScreenshots, "skip-screenshots", false, "skip images that look like memes or screenshots, see -screenshot-rules")
+	screenshotRulesOpt := flag.String("screenshot-rules", downloader.DefaultScreenshotRules, "rules for -skip-screenshots: min-side=<px>, max-ratio=<long side / short side> and <type>=<width>x<height> (either orientation, * for any type), separated by comma")
 	minSizeOpt := flag.String("min-size", "", "minimum size in bytes, common suffixes are allowed")
+	minFreeSpaceOpt := flag.String("min-free-space", "", "stop downloading when less than this many bytes are free in an output directory, common suffixes are allowed")
 	maxSizeOpt := flag.String("max-size", "", "maximum size in bytes, common suffixes are allowed")
+	minSizeTypesOpt := flag.String("min-size-type", "", "minimum size per image type overriding -min-size, e.g. 'gif=100k,jpeg=50k'")
+	maxSizeTypesOpt := flag.String("max-size-type", "", "maximum size per image type overriding -max-size, e.g. 'gif=20M,jpeg=3M'")
+	flag.BoolVar(&dl.Probe, "probe", false, "download and decode images without writing them and print statistics about types, orientations and resolutions")
+	flag.BoolVar(&dl.Unblur, "unblur", false, "download the unblurred preview of spoiler image posts instead of their url, which can be blurred")
+	flag.BoolVar(&dl.SaveText, "save-text", false, "save the text of self posts as markdown files (see -text-template)")
+	flag.BoolVar(&dl.PreferSourceComment, "prefer-source-comment", false, "download the first image link (imgur, direct image links) in a top-level comment by the author of an image post instead of the posted image, e.g. the full resolution source on artist subreddits (one more request per post)")
+	flag.BoolVar(&dl.PreserveTime, "preserve-time", false, "set the modification time of written files to when the submission was posted (album images: when they were uploaded to imgur, if known)")
+	flag.BoolVar(&dl.UserSubredditMap, "user-subreddit-map", false, "write the posts of user feeds (u/<name>) as if they were posted to the subreddit u_<name>, i.e. into one folder per user (filters still see the real subreddit)")
+	flag.BoolVar(&dl.RequireDecodable, "require-decodable", false, "skip downloads that aren't images whose type and dimensions can be read (including videos), even without image filters")
+	flag.BoolVar(&dl.StaticOnly, "static-only", false, "skip animated images (gifs, apngs and webps with more than one frame) and videos, which reads every gif, png and webp completely")
+	flag.BoolVar(&dl.GifToMp4, "gif-to-mp4", false, "transcode downloaded gifs to mp4 with ffmpeg (gifs are kept if ffmpeg isn't installed)")
+	flag.BoolVar(&dl.AutoOrient, "auto-orient", false, "re-encode JPEGs with an EXIF orientation so they are stored in their display orientation")
+	flag.BoolVar(&dl.MetadataRaw, "metadata-raw", false, "write the raw reddit json of the submission to <image path>.json")
+	flag.BoolVar(&dl.CheckTemplate, "check-template", false, "render the path templates for all submissions without downloading and report paths that collide")
+	flag.StringVar(&dl.DedupeReport, "dedupe-report", "", "write the urls and submissions that resolved to identical images (by sha256) as json to this file, needs duplicate detection")
+	flag.BoolVar(&dl.Gallery, "gallery", false, "write an index.html gallery per subreddit")
+	checksumsOpt := flag.String("checksums", "", "write the sha256 of every downloaded file to <file>.sha256 (sidecar) or to SHA256SUMS in its directory (sums), both can be checked with sha256sum -c")
+	archiveOpt := flag.String("archive", "", "write the files of each subreddit into <out>/<subreddit>.zip or .tar instead of to disk (zip|tar), the rendered paths become the entry names")
+	verifyPath := flag.String("verify", "", "decode all images in this directory and report corrupt ones instead of downloading")
+	fixExtensionsPath := flag.String("fix-extensions", "", "rename images in this directory whose extension doesn't match their type instead of downloading")
+	verifyDelete := flag.Bool("verify-delete", false, "delete corrupt images found by -verify")
+	toStdout := flag.Bool("stdout", false, "write the downloaded image to standard output instead of the output directory for piping, writing more than one file fails (the log stays on stderr)")
+	urlsFile := flag.String("urls-file", "", "download the image urls in this file (one per line) instead of scraping subreddits")
+	albumUrl := flag.String("album-url", "", "download this imgur album instead of scraping subreddits")
+	postUrl := flag.String("post", "", "download the images of this reddit post (https://www.reddit.com/r/<subreddit>/comments/<id>/...) instead of scraping subreddits")
+	configFile := flag.String("config", "", "json file with settings per subreddit (search, flair, min_score, filter, single_template, album_template), its subreddits are added to the arguments, it is read again on SIGHUP")
+	subredditsFile := flag.String("subreddits-file", "", "read additional subreddits (one per line) from this file, it is read again on SIGHUP")
+	flag.StringVar(&dl.FailuresFile, "failures-file", "", "write the failed downloads (url, permalink, reason and submission) as json to this file at the end, see -retry-failures-file")
+	retryFailuresFile := flag.String("retry-failures-file", "", "download the submissions in this -failures-file of an earlier run again instead of scraping subreddits")
+	listingFile := flag.String("listing-file", "", "process the submissions of a saved reddit listing json file instead of scraping subreddits")
+	urlsSubreddit := flag.String("urls-subreddit", "urls", "subreddit name used in the path templates for -urls-file and -album-url")
+	filterOpt := flag.String("filter", "", "filter expression, e.g. 'score>100 && width>=1920 && !nsfw && flair~\"OC\"'")
 
 	flag.Usage = func() {
-		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [options] subreddits...\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [options] subreddits|u/<user>|u/<user>/m/<multireddit>|domain:<domain>...\n       %s [options] -urls-file <file>\n       %s [options] -album-url <url>\n       %s [options] -listing-file <file>\n       %s [options] -post <url>\n       %s [options] -retry-failures-file <file>\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 		_, _ = fmt.Fprintln(os.Stderr, "Available options: ")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
+	if *fixExtensionsPath != "" {
+		_, err := downloader.FixExtensions(*fixExtensionsPath)
+		if err != nil {
+			log.Fatalf("error fixing extensions in %s: %v", *fixExtensionsPath, err)
+		}
+		return
+	}
+
+	if *verifyPath != "" {
+		corrupt, err := downloader.VerifyArchive(*verifyPath, *verifyDelete)
+		if err != nil {
+			log.Fatalf("error verifying %s: %v", *verifyPath, err)
+		}
+		if corrupt > 0 && !*verifyDelete {
+			os.Exit(1)
+		}
+		return
+	}
+
 	subreddits := flag.Args()
-	if len(subreddits) == 0 {
+	if len(subreddits) == 0 && *configFile == "" && *subredditsFile == "" && *urlsFile == "" && *albumUrl == "" && *listingFile == "" && *postUrl == "" && *retryFailuresFile == "" {
 		_, _ = fmt.Fprintln(os.Stderr, "No subreddits provided.")
 		flag.Usage()
 		return
 	}
 
 	var err error
-	minSize, err = parseSize(*minSizeOpt)
+	for i, sub := range subreddits {
+		subreddits[i], err = downloader.NormalizeTarget(sub)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid subreddit: %v.\n", err)
+			flag.Usage()
+			return
+		}
+	}
+	// the arguments without the files, which are read again on SIGHUP
+	argTargets := subreddits
+	if *configFile != "" {
+		configured, err := dl.ReadConfig(*configFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid config: %v.\n", err)
+			flag.Usage()
+			return
+		}
+		subreddits = downloader.MergeTargets(subreddits, configured)
+	}
+	if *subredditsFile != "" {
+		fromFile, err := downloader.ReadTargetsFile(*subredditsFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid subreddits file: %v.\n", err)
+			flag.Usage()
+			return
+		}
+		subreddits = downloader.MergeTargets(subreddits, fromFile)
+	}
+
+	if *noCrossposts && *onlyCrossposts {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid crosspost options: -no-crossposts and -only-crossposts exclude each other.")
+		flag.Usage()
+		return
+	}
+
+	dl.ArchiveFormat, err = downloader.ParseArchiveFormat(*archiveOpt)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Invalid min size: %v.\n", err)
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid archive format: %v.\n", err)
 		flag.Usage()
 		return
 	}
-	maxSize, err = parseSize(*maxSizeOpt)
+	if dl.ArchiveFormat != "" && (dl.Gallery || dl.HardlinkDuplicates) {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid archive options: -gallery and -hardlink-duplicates need files on disk and can't be used with -archive.")
+		flag.Usage()
+		return
+	}
+
+	dl.Prefer, err = downloader.ParsePrefer(*preferOpt)
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Invalid max size: %v.\n", err)
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid format preference: %v.\n", err)
 		flag.Usage()
 		return
 	}
 
-	minWidth = int(*minWidthOpt)
-	maxWidth = int(*maxWidthOpt)
-	minHeight = int(*minHeightOpt)
-	maxHeight = int(*maxHeightOpt)
-	maxAspect = *maxAspectOpt
-
-	orientations := strings.Split(*orientation, ",")
-
-	noLandscape = true
-	noPortrait = true
-	noSquare = true
-	for _, o := range orientations {
-		if o == "portrait" {
-			noPortrait = false
-		} else if o == "landscape" {
-			noLandscape = false
-		} else if o == "square" {
-			noSquare = false
-		} else if o == "all" {
-			noPortrait = false
-			noLandscape = false
-			noSquare = false
-		}
-	}
-
-	availableTypes := map[string]string{
-		"png":  "png",
-		"jpg":  "jpeg",
-		"jpeg": "jpeg",
-		"gif":  "gif",
-		"webp": "webp",
-		"tif":  "tiff",
-		"tiff": "tiff",
-		"bmp":  "bmp",
+	dl.Checksums, err = downloader.ParseChecksums(*checksumsOpt)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid checksums mode: %v.\n", err)
+		flag.Usage()
+		return
 	}
-	if *allowedTypes != "" {
-		list := strings.Split(*allowedTypes, ",")
-		for _, t := range list {
-			tt, ok := availableTypes[t]
-			if ok {
-				allowTypes[tt] = struct{}{}
-			}
-		}
+	if dl.ArchiveFormat != "" && dl.Checksums == "sums" {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid checksums options: -checksums sums appends to files on disk and can't be used with -archive, use sidecar.")
+		flag.Usage()
+		return
 	}
 
-	if len(allowTypes) > 0 || noLandscape || noPortrait || minWidth > 0 || minHeight > 0 || maxWidth > 0 || maxHeight > 0 || maxAspect > 0 {
-		parseImages = true
+	if *toStdout {
+		if dl.ArchiveFormat != "" || dl.Gallery || dl.HardlinkDuplicates || dl.MetadataRaw || dl.Checksums != "" || len(outputRoots.roots) > 1 {
+			_, _ = fmt.Fprintln(os.Stderr, "Invalid stdout options: -archive, -gallery, -hardlink-duplicates, -metadata-raw, -checksums and several -out write more than one file and can't be used with -stdout.")
+			flag.Usage()
+			return
+		}
+		dl.Stdout = os.Stdout
 	}
 
-	if *search == "" {
-		search = nil
+	err = downloader.CheckSearchSort(dl.SearchSort, dl.SearchTime)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid search options: %v.\n", err)
+		flag.Usage()
+		return
 	}
 
-	singleTemplate = template.New("name")
-	singleTemplate.Funcs(template.FuncMap{
-		"slugify": slugify,
-	})
-	_, err = singleTemplate.Parse(*singleTemplateStr)
+	err = downloader.CheckDomainSort(dl.DomainSort, dl.DomainTime)
 	if err != nil {
-		log.Fatalf("error parsing template: %v", err)
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid domain options: %v.\n", err)
+		flag.Usage()
+		return
 	}
 
-	albumTemplate = template.New("name")
-	albumTemplate.Funcs(template.FuncMap{
-		"slugify": slugify,
-	})
-	_, err = albumTemplate.Parse(*albumTemplateStr)
+	dl.Geo, err = downloader.ParseGeo(*geoOpt)
 	if err != nil {
-		log.Fatalf("error parsing template: %v", err)
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid geo filter: %v.\n", err)
+		flag.Usage()
+		return
 	}
 
-	httpClient = http.Client{
-		Timeout: time.Second * 10,
+	dl.TitleNormalization, err = downloader.ParseTitleNormalization(*titleNormalizationOpt)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid title normalization: %v.\n", err)
+		flag.Usage()
+		return
 	}
-	redditClient = RedditClient{http: &httpClient}
-	imgurClient = ImgurClient{http: &httpClient}
-
-	throttler = newImmediateTicker(*throttle)
-	submissions := make(chan Submission)
-	go func() {
-		after := make(map[string]string)
-		completed := make(map[string]bool)
-		for _, sub := range subreddits {
-			after[sub] = ""
-			completed[sub] = false
-		}
-
-		page := 1
-		for {
-			allCompleted := true
-			for _, sub := range subreddits {
-				if !completed[sub] {
-					allCompleted = false
-					<-throttler.C
-					log.Printf("fetching page %d on r/%s", page, sub)
-
-					var listing Listing
-					var err error
-
-					var rateLimitDuration time.Duration = 0
-					for {
-						if rateLimitDuration > 0 {
-							time.Sleep(rateLimitDuration)
-						}
-						if search != nil {
-							listing, err = redditClient.GetSearch(sub, SearchListingParams{
-								After:  after[sub],
-								Limit:  int(*pageSize),
-								Search: *search,
-							})
-						} else {
-							listing, err = redditClient.GetNew(sub, NewListingParams{
-								After: after[sub],
-								Limit: int(*pageSize),
-							})
-						}
-						if err == nil {
-							break
-						} else if err == RateLimited {
-							rateLimitDuration += *throttle
-							log.Printf("rate limit reached, retrying after %s", rateLimitDuration.String())
-						} else {
-							log.Printf("fetching failed: %v, retrying", err)
-							<-throttler.C
-						}
-					}
-
-					for _, submission := range listing.Children {
-						// ignore meta submissions
-						if !submission.IsMeta {
-							submissions <- submission
-						}
-					}
-
-					if listing.After == "" {
-						completed[sub] = true
-						log.Printf("completed %s", sub)
-					} else {
-						after[sub] = listing.After
-					}
-				}
-			}
-			page++
-
-			if int(*maxPages) > 0 && page > int(*maxPages) {
-				allCompleted = true
-			}
-
-			if allCompleted {
-				break
-			}
+	if dl.DedupeTitles && dl.TitlesFile != "" {
+		err = dl.ReadTitlesFile(dl.TitlesFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid titles file: %v.\n", err)
+			flag.Usage()
+			return
 		}
-		close(submissions)
-	}()
-
-	for submission := range submissions {
-		if submission.Nsfw && !nsfw {
-			log.Printf("skipping NSFW: %s (%s)", submission.Url, submission.Permalink)
-		} else if submission.Score < *minScore {
-			log.Printf("skipping score below %d (has %d): %s (%s)", *minScore, submission.Score, submission.Url, submission.Permalink)
-		} else {
-			_ = fetchSubmission(submission)
+	}
+	if dl.FastDedupBytes <= 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid fast dedup options: -fast-dedup-bytes must be positive.")
+		flag.Usage()
+		return
+	}
+	if dl.OnlyNewHashes {
+		if dl.HashesFile == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Invalid hash options: -only-new-hashes needs -hashes-file.")
+			flag.Usage()
+			return
 		}
+		// new means not a duplicate of anything seen before
+		dl.SkipDuplicates = true
+		dl.SkipDuplicatesInAlbums = true
 	}
-	log.Printf("finished")
-}
-
-func parseSize(size string) (int, error) {
-	size = strings.TrimSpace(strings.ToLower(size))
-	if size == "" {
-		return 0, nil
-	}
-	var numStr string
-	var suffix string
-	// split into num and suffix on first non-digit rune
-	for i, ch := range size {
-		if !unicode.IsDigit(ch) {
-			numStr = strings.TrimSpace(size[:i])
-			suffix = strings.TrimSpace(size[i:])
-			break
+	if dl.HashesFile != "" {
+		err = dl.ReadHashesFile(dl.HashesFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid hashes file: %v.\n", err)
+			flag.Usage()
+			return
 		}
 	}
-	num, err := strconv.ParseFloat(numStr, 64)
+
+	dl.MinSize, err = downloader.ParseSize(*minSizeOpt)
 	if err != nil {
-		return 0, err
-	}
-
-	var factor float64
-	if suffix == "" || suffix == "b" {
-		factor = 1
-	} else if suffix == "k" || suffix == "kb" {
-		factor = 1024
-	} else if suffix == "m" || suffix == "mb" {
-		factor = 1024 * 1024
-	} else if suffix == "g" || suffix == "gb" {
-		factor = 1024 * 1024 * 1024
-	} else {
-		return 0, fmt.Errorf("invalid size suffix: %s", suffix)
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid min size: %v.\n", err)
+		flag.Usage()
+		return
 	}
-	return int(num * factor), nil
-}
-
-func fetchSubmission(submission Submission) error {
-	if submission.PostHint == "image" {
-		return fetchSingleImage(submission.Url, submission)
-	} else if submission.Domain == "imgur.com" {
-		return fetchImgur(submission)
-	} else {
-		return fmt.Errorf("could not fetch %s, unknown service %s", submission.Url, submission.Domain)
+	dl.MaxSize, err = downloader.ParseSize(*maxSizeOpt)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid max size: %v.\n", err)
+		flag.Usage()
+		return
 	}
-}
 
-func fetchSingleImage(u string, submission Submission) error {
-	if skipDuplicates {
-		_, exists := knownUrls[u]
-		if exists {
-			log.Printf("skipping %s\n", u)
-			return nil
-		}
-		knownUrls[u] = struct{}{}
+	dl.MinFreeSpace, err = downloader.ParseSize(*minFreeSpaceOpt)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid min free space: %v.\n", err)
+		flag.Usage()
+		return
 	}
 
-	resp, err := httpClient.Get(u)
+	dl.MinSizeTypes, err = downloader.ParseSizeTypes(*minSizeTypesOpt)
 	if err != nil {
-		log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-		return err
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid min size per type: %v.\n", err)
+		flag.Usage()
+		return
 	}
-	defer func() {
-		_, _ = io.Copy(ioutil.Discard, resp.Body)
-		err := resp.Body.Close()
-		if err != nil {
-			log.Printf("error closing response body: %v", err)
-		}
-	}()
-
-	if resp.StatusCode == 404 || (resp.Request.URL.Host == "i.imgur.com" && strings.HasSuffix(resp.Request.URL.Path, "removed.png")) {
-		log.Printf("fetching %s (%s) => not found\n", u, submission.Permalink)
-		return fmt.Errorf("image not found")
-	} else if resp.StatusCode >= 300 {
-		log.Printf("fetching %s (%s) => HTTP status %d\n", u, submission.Permalink, resp.StatusCode)
-		return fmt.Errorf("status code is not 2XX")
+	dl.MaxSizeTypes, err = downloader.ParseSizeTypes(*maxSizeTypesOpt)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid max size per type: %v.\n", err)
+		flag.Usage()
+		return
 	}
 
-	var data []byte
-	if skipDuplicates {
-		hasher := sha256.New()
-		tee := io.TeeReader(resp.Body, hasher)
-		data, err = ioutil.ReadAll(tee)
+	for i, root := range outputRoots.roots {
+		root, err = downloader.NormalizeOutputRoot(root)
 		if err != nil {
-			log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-			return err
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid output directory: %v.\n", err)
+			flag.Usage()
+			return
 		}
-		hash := hasher.Sum(nil)
-		hashString := string(hash)
-		_, exists := knownHashes[hashString]
-		if exists {
-			log.Printf("fetching %s (%s) => hash exists already, skipping", u, submission.Permalink)
-			return nil
+		if dl.WritesFiles() {
+			err = downloader.CheckOutputRoot(root)
+			if err != nil {
+				log.Fatalf("output directory %s is not writable: %v", root, err)
+			}
 		}
-		knownHashes[string(hash)] = struct{}{}
-	} else {
-		data, err = ioutil.ReadAll(resp.Body)
+		if i == 0 {
+			dl.OutputRoot = root
+		} else {
+			dl.ExtraOutputRoots = append(dl.ExtraOutputRoots, root)
+		}
+	}
+
+	if *filterOpt != "" {
+		dl.Filter, err = downloader.CompileFilter(*filterOpt)
 		if err != nil {
-			log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-			return err
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid filter: %v.\n", err)
+			flag.Usage()
+			return
 		}
 	}
 
-	if len(data) < minSize {
-		log.Printf("fetching %s (%s) => smaller than %d bytes, skipping", u, submission.Permalink, minSize)
-		return nil
+	dl.MinWidth = int(*minWidthOpt)
+	dl.MaxWidth = int(*maxWidthOpt)
+	dl.MinHeight = int(*minHeightOpt)
+	dl.MaxHeight = int(*maxHeightOpt)
+	dl.MaxAspect = *maxAspectOpt
+
+	if dl.SquareTolerance < 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid square tolerance: must not be negative.")
+		flag.Usage()
+		return
 	}
-	if maxSize > 0 && len(data) > maxSize {
-		log.Printf("fetching %s (%s) => greater than %d bytes, skipping", u, submission.Permalink, maxSize)
-		return nil
+	dl.SetOrientations(strings.Split(*orientation, ","))
+	if *allowedTypes != "" {
+		dl.SetTypes(strings.Split(*allowedTypes, ","))
 	}
 
-	if ok, msg := checkImage(data); !ok {
-		log.Printf("fetching %s (%s) => %s, skipping", u, submission.Permalink, msg)
-		return nil
+	if dl.SkipScreenshots {
+		dl.ScreenshotRules, err = downloader.ParseScreenshotRules(*screenshotRulesOpt)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid screenshot rules: %v.\n", err)
+			flag.Usage()
+			return
+		}
 	}
 
-	parsedUrl, _ := url.Parse(u)
-	ext := path.Ext(parsedUrl.Path)
-
-	contentType := resp.Header.Get("Content-Type")
-
-	if contentType != "" {
-		exts, err := mime.ExtensionsByType(contentType)
-		if err == nil && len(exts) > 0 {
-			if ext == "" {
-				ext = exts[0]
-			} else {
-				valid := false
-				for _, e := range exts {
-					if e == ext {
-						valid = true
-						break
-					}
-				}
-				if !valid {
-					ext = exts[0]
-				}
+	if *contentTypes != "" {
+		for _, t := range strings.Split(*contentTypes, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t != "" {
+				dl.AllowContentTypes = append(dl.AllowContentTypes, t)
 			}
 		}
 	}
 
-	created := time.Unix(int64(submission.CreatedUtc), 0)
-
-	templateData := struct {
-		Ext        string
-		Submission Submission
-		Time       time.Time
-		Timestamp  string
-	}{
-		Ext:        ext,
-		Submission: submission,
-		Time:       created,
-		Timestamp:  created.Format("2006-01-02-15-04-05"),
+	if *search == "" {
+		search = nil
 	}
 
-	var name bytes.Buffer
-	err = singleTemplate.Execute(&name, templateData)
+	dl.SingleTemplate, err = downloader.ParseTemplate(*singleTemplateStr)
+	if err != nil {
+		log.Fatalf("error parsing template: %v", err)
+	}
+	dl.AlbumTemplate, err = downloader.ParseTemplate(*albumTemplateStr)
 	if err != nil {
-		panic(fmt.Errorf("template error: %v", err))
+		log.Fatalf("error parsing template: %v", err)
 	}
-
-	p := name.String()
-
-	if !filepath.IsAbs(p) {
-		p = outputRoot + "/" + p
+	dl.TextTemplate, err = downloader.ParseTemplate(*textTemplateStr)
+	if err != nil {
+		log.Fatalf("error parsing template: %v", err)
 	}
 
-	if !overwrite {
-		if _, err := os.Stat(p); err == nil || !os.IsNotExist(err) {
-			// exists or some error except "not exist"
-			log.Printf("fetching %s (%s) => file exists, overwrite disabled", u, submission.Permalink)
-			return nil
+	dl.MinScore = *minScore
+	dl.NoCrossposts = *noCrossposts
+	dl.OnlyCrossposts = *onlyCrossposts
+	dl.OnlyOc = *onlyOc
+
+	if *proxyList != "" {
+		proxies, err := downloader.ReadLines(*proxyList)
+		if err != nil {
+			log.Fatalf("error reading proxy list: %v", err)
+		}
+		pool, err := downloader.NewProxyPool(proxies, *proxyRandom)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid proxy list: %v.\n", err)
+			flag.Usage()
+			return
+		}
+		dl.ImageClient.Transport = pool
+		if *proxyApi {
+			dl.HttpClient.Transport = pool
 		}
 	}
 
-	dir := filepath.Dir(p)
-	_ = os.MkdirAll(dir, os.ModeDir)
-	err = ioutil.WriteFile(p, data, os.ModePerm)
-	if err != nil {
-		log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-		return err
+	if *imgurClientId != "" {
+		dl.SetImgurClientId(*imgurClientId)
 	}
-	if !quiet {
-		log.Printf("fetching %s (%s) => %s", u, submission.Permalink, p)
+
+	if *hostTimings {
+		dl.EnableHostTimings()
 	}
-	return nil
-}
 
-func fetchImgur(submission Submission) error {
-	u, err := url.Parse(submission.Url)
-	if err != nil {
-		log.Printf("invalid url: %s", submission.Url)
-		return err
-	}
-	if strings.HasPrefix(u.Path, "/a/") {
-		if noAlbums {
-			log.Printf("skipping imgur album: %s\n", submission.Url)
-			return nil
-		}
-		albumId := strings.TrimPrefix(u.Path, `/a/`)
-		if skipDuplicates {
-			_, exists := knownUrls[submission.Url]
-			if exists {
-				log.Printf("skipping imgur album: %s\n", submission.Url)
-				return nil
-			}
-			knownUrls[submission.Url] = struct{}{}
-		}
-		album, err := imgurClient.GetAlbum(albumId)
+	if *cookieFile != "" {
+		jar, err := downloader.ReadCookieFile(*cookieFile)
 		if err != nil {
-			log.Printf("fetching imgur album: %s (%s) => %v", submission.Url, submission.Permalink, err)
-			return err
+			log.Fatalf("error reading cookie file: %v", err)
 		}
+		dl.SetRedditCookies(jar)
+	} else if dl.AcceptQuarantine {
+		_, _ = fmt.Fprintln(os.Stderr, "Invalid quarantine options: -accept-quarantine needs the session of -cookie-file.")
+		flag.Usage()
+		return
+	}
 
-		for i, img := range album.Images {
-			u := fmt.Sprintf(`https://i.imgur.com/%s%s`, img.Hash, img.Ext)
-			if skipDuplicatesInAlbums {
-				_, exists := knownUrls[u]
-				if exists {
-					log.Printf("skipping %s (%s)\n", u, submission.Permalink)
-					continue
-				}
-				knownUrls[u] = struct{}{}
-			}
-			resp, err := httpClient.Get(u)
-			if err != nil {
-				log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-				continue
-			}
-			defer func() {
-				_, _ = io.Copy(ioutil.Discard, resp.Body)
-				err := resp.Body.Close()
-				if err != nil {
-					log.Printf("error closing response body: %v", err)
-				}
-			}()
-
-			if strings.HasSuffix(resp.Request.URL.Path, "removed.png") {
-				log.Printf("fetching %s (%s) => not found\n", u, submission.Permalink)
-				continue
-			} else if resp.StatusCode >= 300 {
-				log.Printf("fetching %s (%s) => HTTP status %d", u, submission.Permalink, resp.StatusCode)
-				continue
-			}
+	if *adaptive {
+		dl.SetAdaptiveThrottle()
+	} else {
+		dl.SetThrottle(*throttle, int(*listingConcurrency))
+	}
+	dl.SetWriteThrottle(*writeThrottle)
+	dl.SetMaxRuntime(*maxRuntime)
+	stopProgress := func() {}
+	if *tui {
+		var ok bool
+		if stopProgress, ok = dl.StartProgress(int(*maxPages)); ok {
+			// the progress replaces the routine lines
+			*quiet = true
+			*quietSkips = true
+		} else {
+			log.Printf("-tui => stderr isn't a terminal, logging as usual")
+		}
+	}
+	dl.OnDownload = func(e downloader.DownloadEvent) {
+		if (e.Skipped && *quietSkips) || (!e.Skipped && *quiet) {
+			return
+		}
+		log.Print(e.Message)
+	}
 
-			var data []byte
+	var rng *rand.Rand
+	if *random {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		log.Printf("shuffling pages with seed %d", *seed)
+		rng = rand.New(rand.NewSource(*seed))
+	}
 
-			if skipDuplicatesInAlbums {
-				hasher := sha256.New()
-				tee := io.TeeReader(resp.Body, hasher)
-				data, err = ioutil.ReadAll(tee)
-				if err != nil {
-					log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-					continue
-				}
-				hash := hasher.Sum(nil)
-				hashString := string(hash)
-				_, exists := knownHashes[hashString]
-				if exists {
-					log.Printf("fetching %s (%s) => hash exists already, skipping\n", u, submission.Permalink)
-					continue
-				}
-				knownHashes[string(hash)] = struct{}{}
-			} else {
-				data, err = ioutil.ReadAll(resp.Body)
-				if err != nil {
-					log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-					continue
-				}
-			}
+	var statsServer *http.Server
+	if *httpAddr != "" {
+		statsServer = dl.StartStatsServer(*httpAddr)
+	}
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		metricsServer = dl.StartMetricsServer(*metricsAddr)
+	}
+	if dl.ArchiveFormat != "" {
+		dl.CloseArchivesOnSignal()
+	}
+	dl.PauseOnSignal()
 
-			if len(data) < minSize {
-				log.Printf("fetching %s (%s) => smaller than %d bytes, skipping", u, submission.Permalink, minSize)
-				continue
+	submissions := make(chan downloader.Submission)
+	// the lister of subreddits, nil for the other sources
+	var l *downloader.Lister
+	run := func() {
+		if *mergeSort {
+			go l.RunMerged(submissions)
+		} else {
+			l.Rng = rng
+			go l.Run(submissions)
+		}
+	}
+	if *urlsFile != "" {
+		urls, err := downloader.ReadLines(*urlsFile)
+		if err != nil {
+			log.Fatalf("error reading urls file: %v", err)
+		}
+		go func() {
+			for _, u := range urls {
+				submissions <- downloader.UrlSubmission(u, *urlsSubreddit)
 			}
-			if maxSize > 0 && len(data) > maxSize {
-				log.Printf("fetching %s (%s) => greater than %d bytes, skipping", u, submission.Permalink, maxSize)
-				continue
+			close(submissions)
+		}()
+	} else if *listingFile != "" {
+		listed, err := downloader.ReadListingFile(*listingFile)
+		if err != nil {
+			log.Fatalf("error reading listing file: %v", err)
+		}
+		go func() {
+			for _, submission := range listed {
+				submissions <- submission
 			}
-
-			if ok, msg := checkImage(data); !ok {
-				log.Printf("fetching %s (%s) => %s, skipping", u, submission.Permalink, msg)
-				continue
+			close(submissions)
+		}()
+	} else if *retryFailuresFile != "" {
+		failed, err := downloader.ReadFailuresFile(*retryFailuresFile)
+		if err != nil {
+			log.Fatalf("error reading failures file: %v", err)
+		}
+		go func() {
+			for _, submission := range failed {
+				submissions <- submission
 			}
+			close(submissions)
+		}()
+	} else if *albumUrl != "" {
+		submission, err := downloader.AlbumSubmission(*albumUrl, *urlsSubreddit)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid album url: %v.\n", err)
+			flag.Usage()
+			return
+		}
+		go func() {
+			submissions <- submission
+			close(submissions)
+		}()
+	} else if *postUrl != "" {
+		submission, err := dl.PostSubmission(*postUrl)
+		if err != nil {
+			log.Fatalf("error fetching post %s: %v", *postUrl, err)
+		}
+		go func() {
+			submissions <- submission
+			close(submissions)
+		}()
+	} else {
+		l = dl.NewLister(subreddits)
+		l.PageSize = int(*pageSize)
+		l.MaxPages = int(*maxPages)
+		l.Search = search
+		l.SubredditTimeout = *subredditTimeout
+		l.FailFastOnAuth = *failFastOnAuth
+		if *listingConcurrency > 1 {
+			l.Concurrency = int(*listingConcurrency)
+		}
+		if *configFile != "" || *subredditsFile != "" {
+			downloader.ReloadOnSignal(l, argTargets, *configFile, *subredditsFile)
+		}
+		run()
+	}
 
-			created := time.Unix(int64(submission.CreatedUtc), 0)
-
-			templateData := struct {
-				Ext        string
-				Submission Submission
-				Image      AlbumImage
-				Time       time.Time
-				Timestamp  string
-				Num        int
-			}{
-				Ext:        img.Ext,
-				Submission: submission,
-				Image:      img,
-				Time:       created,
-				Timestamp:  created.Format("2006-01-02-15-04-05"),
-				Num:        i + 1,
+	retries := uint(0)
+	// -fail-fast-on-auth exits with 1 even without -strict
+	stoppedOnAuth := false
+loop:
+	for {
+		for {
+			var submission downloader.Submission
+			var ok bool
+			select {
+			case submission, ok = <-submissions:
+			case <-dl.Done():
 			}
-
-			var name bytes.Buffer
-			err = albumTemplate.Execute(&name, templateData)
-			if err != nil {
-				panic(fmt.Errorf("template error: %v", err))
+			// checked again, select picks a pending submission as often as the deadline
+			if dl.RuntimeExceeded() {
+				s := dl.Stats()
+				log.Printf("stopping: -max-runtime of %s exceeded after %d submissions, %d downloaded (%d bytes), %d skipped, %d failed",
+					maxRuntime.String(), s.Submissions, s.Downloaded, s.Bytes, s.Skipped, s.Failed)
+				break loop
 			}
-
-			p := name.String()
-			if !filepath.IsAbs(p) {
-				p = outputRoot + "/" + p
+			if !ok {
+				break
 			}
-
-			if !overwrite {
-				if _, err := os.Stat(p); err != nil {
-					// exists or some error
-					log.Printf("fetching %s (%s) => file exists, overwrite disabled", u, submission.Permalink)
-					continue
+			if dl.WritesFiles() {
+				if err := dl.CheckFreeSpace(); err != nil {
+					dl.LogFailure("stopping: %v", err)
+					break loop
 				}
 			}
-
-			dir := filepath.Dir(p)
-			_ = os.MkdirAll(dir, os.ModeDir)
-			err = ioutil.WriteFile(p, data, os.ModePerm)
-			if err != nil {
-				log.Printf("fetching %s (%s) => %v", u, submission.Permalink, err)
-				continue
-			}
-			if !quiet {
-				log.Printf("fetching %s (%s) => %s\n", u, submission.Permalink, p)
-			}
+			dl.Handle(submission)
 		}
-		return nil
-	} else {
-		imgUrl := `https://i.imgur.com` + u.Path + `.png`
-		return fetchSingleImage(imgUrl, submission)
-	}
-}
-
-func slugify(str string) string {
-	return slug.Make(str)
-}
-
-func newImmediateTicker(repeat time.Duration) *time.Ticker {
-	ticker := time.NewTicker(repeat)
-	oc := ticker.C
-	nc := make(chan time.Time, 1)
-	go func() {
-		nc <- time.Now()
-		for tm := range oc {
-			nc <- tm
-		}
-	}()
-	ticker.C = nc
-	return ticker
-}
-
-func checkImage(data []byte) (bool, string) {
-	if !parseImages {
-		return true, ""
-	}
-	cfg, imgType, err := image.DecodeConfig(bytes.NewReader(data))
-	if err != nil {
-		return false, "failed to parse image"
-	}
-	if _, ok := allowTypes[imgType]; !ok && len(allowTypes) > 0 {
-		return false, fmt.Sprintf("type %s not allowed", imgType)
-	}
-	if noPortrait && cfg.Height > cfg.Width {
-		return false, "portrait orientation"
-	}
-	if noLandscape && cfg.Width > cfg.Height {
-		return false, "landscape orientation"
-	}
-	if noSquare && cfg.Width == cfg.Height {
-		return false, "square orientation"
-	}
-	if cfg.Width < minWidth {
-		return false, fmt.Sprintf("width < %d", minWidth)
+		if l != nil && l.Err() != nil {
+			dl.LogFailure("stopping: %v", l.Err())
+			stoppedOnAuth = true
+			break
+		}
+		if l == nil || retries >= *retryEmpty {
+			break
+		}
+		if l = l.RetryEmpty(); l == nil {
+			break
+		}
+		retries++
+		log.Printf("retrying the subreddits without downloads (pass %d of %d)", retries, *retryEmpty)
+		submissions = make(chan downloader.Submission)
+		run()
 	}
-	if cfg.Height < minHeight {
-		return false, fmt.Sprintf("height < %d", minWidth)
+	dl.Finish()
+	stopProgress()
+	if statsServer != nil {
+		// os.Exit below skips deferred calls
+		downloader.StopStatsServer(statsServer)
 	}
-	if maxWidth > 0 && cfg.Width > maxWidth {
-		return false, fmt.Sprintf("width > %d", maxWidth)
+	if metricsServer != nil {
+		downloader.StopStatsServer(metricsServer)
 	}
-	if maxHeight > 0 && cfg.Height > maxHeight {
-		return false, fmt.Sprintf("height > %d", maxHeight)
+	if dl.CheckTemplate && dl.ReportTemplateCollisions() > 0 {
+		log.Printf("finished")
+		os.Exit(1)
 	}
-	if maxAspect > 0.0 && float64(cfg.Height)/float64(cfg.Width) > maxAspect {
-		return false, fmt.Sprintf("aspect ratio %.2f > %.2f", float64(cfg.Height)/float64(cfg.Width), maxAspect)
+	if dl.Failures() > 0 && (*strict || stoppedOnAuth) {
+		log.Printf("finished with %d failures", dl.Failures())
+		os.Exit(1)
 	}
-	return true, ""
+	log.Printf("finished")
 }